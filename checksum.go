@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// S3's "flexible checksums" let an uploader pick a stronger (or
+// cheaper) algorithm than MD5 for S3 to check the data against.  The
+// checksum of each part is sent along with it, in an
+// x-amz-checksum-ALGORITHM header, for S3 to validate.
+//
+// S3 then stores a checksum for the object: for single PUTs, that is
+// the checksum of the whole object, but for multipart uploads, it is
+// a "composite" checksum, of the (binary) checksums of the parts,
+// followed by a dash and the number of parts, just like the ETag.
+// We compute that ourselves, and check it against what S3 says, and
+// (as we do for MD5s) store the checksum of the whole file in the
+// object's metadata, so that `get` can check downloads against it.
+
+// A checksumAlgorithm is one of the flexible checksum algorithms.
+type checksumAlgorithm struct {
+	Name string // as given to --checksum, i.e. "sha256"
+	new  func() hash.Hash
+}
+
+var checksumAlgorithms = []*checksumAlgorithm{
+	{Name: "crc32", new: func() hash.Hash { return crc32.NewIEEE() }},
+	{Name: "crc32c", new: func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }},
+	{Name: "sha1", new: sha1.New},
+	{Name: "sha256", new: sha256.New},
+}
+
+// checksumAlgorithmNamed looks up a checksum algorithm by name, as
+// given to --checksum, or as S3 names them (i.e. "SHA256").
+func checksumAlgorithmNamed(name string) (*checksumAlgorithm, error) {
+	var names []string
+	for _, a := range checksumAlgorithms {
+		if strings.EqualFold(a.Name, name) {
+			return a, nil
+		}
+		names = append(names, a.Name)
+	}
+	return nil, fmt.Errorf("unrecognized checksum algorithm '%s' (must be one of %s)", name, strings.Join(names, ", "))
+}
+
+// Header is the x-amz-checksum-* header that carries checksums made
+// with this algorithm.
+func (a *checksumAlgorithm) Header() string {
+	return "X-Amz-Checksum-" + a.Name
+}
+
+// S3 names the algorithms in upper case, i.e. in the
+// x-amz-checksum-algorithm header.
+func (a *checksumAlgorithm) String() string {
+	return strings.ToUpper(a.Name)
+}
+
+// meta is the (x-amz-meta-*) metadata key that holds the checksum of
+// the whole object.
+func (a *checksumAlgorithm) meta() string {
+	return a.Name + "chksum"
+}
+
+// sum checksums data, base64-encoded, the way S3 wants it.
+func (a *checksumAlgorithm) sum(data []byte) string {
+	h := a.new()
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// composite computes the checksum that S3 gives to an object made up
+// of parts with the given (base64-encoded) checksums, or "" if any of
+// them is missing, or isn't valid base64.
+func (a *checksumAlgorithm) composite(sums []string) string {
+	h := a.new()
+	for _, s := range sums {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || s == "" {
+			return ""
+		}
+		h.Write(b)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(sums))
+}
+
+// checksum returns the algorithm and (base64-encoded) checksum of the
+// whole object that it has, if it has one: either from S3 itself, or
+// from the metadata that `put --checksum` leaves behind.  Composite
+// checksums aren't any use for checking the whole object, and so are
+// skipped.
+func (info objectInfo) checksum() (*checksumAlgorithm, string) {
+	for _, a := range checksumAlgorithms {
+		if v := info.Checksums[a.Name]; v != "" && !strings.Contains(v, "-") {
+			return a, v
+		}
+	}
+	for _, a := range checksumAlgorithms {
+		if v := info.Metadata[a.meta()]; v != "" {
+			return a, v
+		}
+	}
+	return nil, ""
+}
+
+// checksumsFrom reads the x-amz-checksum-* headers of a HEAD (or GET)
+// response, which S3 only sends if asked to, via x-amz-checksum-mode.
+func checksumsFrom(get func(string) string) map[string]string {
+	sums := make(map[string]string)
+	for _, a := range checksumAlgorithms {
+		if v := get(a.Header()); v != "" {
+			sums[a.Name] = v
+		}
+	}
+	return sums
+}
+
+// checksums holds a flexible checksum, in whichever XML element S3
+// uses for its algorithm, for parts in ListParts and
+// CompleteMultipartUpload, and for the object itself in the result
+// of the latter.
+type checksums struct {
+	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (c *checksums) field(a *checksumAlgorithm) *string {
+	switch a.Name {
+	case "crc32":
+		return &c.ChecksumCRC32
+	case "crc32c":
+		return &c.ChecksumCRC32C
+	case "sha1":
+		return &c.ChecksumSHA1
+	default:
+		return &c.ChecksumSHA256
+	}
+}
+
+func (c checksums) get(a *checksumAlgorithm) string {
+	return *c.field(a)
+}
+
+func (c *checksums) set(a *checksumAlgorithm, v string) {
+	*c.field(a) = v
+}
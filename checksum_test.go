@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+)
+
+const quickFox = "The quick brown fox jumps over the lazy dog"
+
+// The expected checksums here were worked out independently, and are
+// base64-encoded (big-endian, for the CRCs), as S3 has them.
+var checksumTests = []struct {
+	alg       string
+	sum       string // of quickFox
+	parts     []string
+	composite string // of quickFox, in two parts
+}{
+	{
+		alg:       "crc32",
+		sum:       "QU+jOQ==",
+		parts:     []string{"iLB14g==", "GHhnlA=="},
+		composite: "3uPqzg==-2",
+	},
+	{
+		alg:       "crc32c",
+		sum:       "ImIEBA==",
+		parts:     []string{"RmdbuQ==", "H9ktkg=="},
+		composite: "iVzxaw==-2",
+	},
+	{
+		alg:       "sha1",
+		sum:       "L9ThxnotKPzthJ7hu3bnORuT6xI=",
+		parts:     []string{"PjQ2oHPnLFMAzcps5cMh5J38QS4=", "x2fWkmcQ170IQyaFu6aipnWg3+w="},
+		composite: "kF1D8PCL0JhDu0EAELOdAdjwfSc=-2",
+	},
+	{
+		alg:       "sha256",
+		sum:       "16j7swfXgJRpypq8sAguT41WUeRtPNt2LQLQvzfJ5ZI=",
+		parts:     []string{"sp1m5W7ZDM6bAWXEP+3sYStgoHGXTYvkUT4YWA1Vtb0=", "n0lBn+Yt6XfMaPgISlJL/1LHuQ7KZZRZuL9VuzuhKVc="},
+		composite: "9d7hWgYbuzPq7sMwvNNJJDT2luWCnWuBhKmWz3o6pXM=-2",
+	},
+}
+
+func TestChecksumSum(t *testing.T) {
+	for _, test := range checksumTests {
+		a, err := checksumAlgorithmNamed(test.alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.sum([]byte(quickFox)); got != test.sum {
+			t.Errorf("%s: sum() = %s, wanted %s", test.alg, got, test.sum)
+		}
+		for i, part := range []string{quickFox[:20], quickFox[20:]} {
+			if got := a.sum([]byte(part)); got != test.parts[i] {
+				t.Errorf("%s: sum() of part %d = %s, wanted %s", test.alg, i+1, got, test.parts[i])
+			}
+		}
+	}
+}
+
+func TestChecksumComposite(t *testing.T) {
+	for _, test := range checksumTests {
+		a, _ := checksumAlgorithmNamed(test.alg)
+		if got := a.composite(test.parts); got != test.composite {
+			t.Errorf("%s: composite() = %s, wanted %s", test.alg, got, test.composite)
+		}
+
+		// one part is the checksum of the checksum, not the checksum.
+		one := a.composite([]string{test.sum})
+		if one == test.sum+"-1" || one[len(one)-2:] != "-1" {
+			t.Errorf("%s: wrong composite() for a single part: %s", test.alg, one)
+		}
+
+		if got := a.composite([]string{test.parts[0], ""}); got != "" {
+			t.Errorf("%s: composite() with a missing part = %s, wanted nothing", test.alg, got)
+		}
+		if got := a.composite([]string{test.parts[0], "not base64!"}); got != "" {
+			t.Errorf("%s: composite() with a bad part = %s, wanted nothing", test.alg, got)
+		}
+	}
+}
+
+func TestChecksumAlgorithmNamed(t *testing.T) {
+	for _, name := range []string{"sha256", "SHA256", "crc32c", "CRC32C"} {
+		if a, err := checksumAlgorithmNamed(name); err != nil {
+			t.Errorf("checksumAlgorithmNamed(%s) failed: %s", name, err)
+		} else if a.Header() != "X-Amz-Checksum-"+a.Name {
+			t.Errorf("wrong header %s for %s", a.Header(), name)
+		}
+	}
+	if _, err := checksumAlgorithmNamed("md5"); err == nil {
+		t.Errorf("checksumAlgorithmNamed(md5) should have failed")
+	}
+}
+
+func TestObjectInfoChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		info objectInfo
+		alg  string
+		sum  string
+	}{
+		{
+			name: "nothing",
+			info: objectInfo{},
+		},
+		{
+			name: "whole-object checksum from S3",
+			info: objectInfo{Checksums: map[string]string{"sha256": "full="}},
+			alg:  "sha256",
+			sum:  "full=",
+		},
+		{
+			name: "composite checksum from S3 is skipped",
+			info: objectInfo{Checksums: map[string]string{"sha256": "composite=-3"}},
+		},
+		{
+			name: "composite checksum from S3, and the whole thing in the metadata",
+			info: objectInfo{
+				Checksums: map[string]string{"sha256": "composite=-3"},
+				Metadata:  map[string]string{"sha256chksum": "whole="},
+			},
+			alg: "sha256",
+			sum: "whole=",
+		},
+		{
+			name: "S3's checksum wins over the metadata",
+			info: objectInfo{
+				Checksums: map[string]string{"crc32c": "fromS3=="},
+				Metadata:  map[string]string{"sha1chksum": "fromMeta="},
+			},
+			alg: "crc32c",
+			sum: "fromS3==",
+		},
+	}
+
+	for _, test := range tests {
+		a, sum := test.info.checksum()
+		name := ""
+		if a != nil {
+			name = a.Name
+		}
+		if name != test.alg || sum != test.sum {
+			t.Errorf("%s: checksum() = %q, %q; wanted %q, %q", test.name, name, sum, test.alg, test.sum)
+		}
+	}
+}
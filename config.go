@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// The configuration file is a simple INI-style file, with one
+// [section] per named profile, each holding `key = value` settings:
+//
+//	[default]
+//	aki    = AKIA...
+//	key    = ...
+//	region = us-west-2
+//
+//	[minio]
+//	url          = https://minio.example.com
+//	path-buckets = yes
+//	bucket       = scratch
+//
+// Blank lines, and lines starting with # or ; are ignored.
+//
+// Settings are applied in order of precedence: command-line flags
+// first, then environment variables, then the profile, and finally
+// the built-in defaults.
+
+// settings lists the keys a profile can set, along with the
+// environment variable that would otherwise set the same thing.
+var settings = []struct {
+	Key string
+	Env string
+}{
+	{"aki", "S3_AKI"},
+	{"key", "S3_KEY"},
+	{"session-token", "S3_SESSION_TOKEN"},
+	{"assume-role", "S3_ASSUME_ROLE"},
+	{"external-id", "S3_EXTERNAL_ID"},
+	{"role-session-name", "S3_ROLE_SESSION_NAME"},
+	{"mfa-serial", "S3_MFA_SERIAL"},
+	{"sts-url", "S3_STS_URL"},
+	{"url", "S3_URL"},
+	{"region", "S3_REGION"},
+	{"path-buckets", "S3_USE_PATH"},
+	{"insecure", "S3_INSECURE"},
+	{"bucket", "S3_BUCKET"},
+}
+
+func knownSetting(key string) bool {
+	for _, s := range settings {
+		if s.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// A profile is one [section] of the configuration file.
+type profile map[string]string
+
+// configFile returns the path to the configuration file, which lives
+// in $XDG_CONFIG_HOME (or ~/.config, if that isn't set), unless
+// $S3_CONFIG says otherwise.
+func configFile() string {
+	if f := os.Getenv("S3_CONFIG"); f != "" {
+		return f
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "s3", "config")
+}
+
+// readConfig parses the configuration file into its profiles.  A
+// missing file is not an error; there just aren't any profiles.
+// The AWS CLI's credentials and config files use the same format,
+// so readConfig is used for those, too.
+func readConfig(file string) (map[string]profile, error) {
+	profiles := make(map[string]profile)
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var current profile
+	lines := bufio.NewScanner(f)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if name, ok := section(line); ok {
+			if _, ok := profiles[name]; !ok {
+				profiles[name] = make(profile)
+			}
+			current = profiles[name]
+			continue
+		}
+
+		l := strings.SplitN(line, "=", 2)
+		if len(l) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected `key = value`", file, n)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("%s:%d: setting outside of a [profile] section", file, n)
+		}
+		current[strings.ToLower(strings.TrimSpace(l[0]))] = strings.TrimSpace(l[1])
+	}
+	return profiles, lines.Err()
+}
+
+func section(line string) (string, bool) {
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return strings.TrimSpace(line[1 : len(line)-1]), true
+	}
+	return "", false
+}
+
+// writeSetting sets a key in a profile, in the configuration file,
+// creating the file (or the profile) as needed.  Everything else in
+// the file, comments included, is left as it was.
+func writeSetting(file, name, key, value string) error {
+	var lines []string
+	if b, err := ioutil.ReadFile(file); err == nil {
+		lines = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	setting := fmt.Sprintf("%s = %s", key, value)
+	in, end := false, -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if s, ok := section(line); ok {
+			in = s == name
+			continue
+		}
+		if !in {
+			continue
+		}
+		if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, ";") {
+			end = i
+			l := strings.SplitN(line, "=", 2)
+			if strings.ToLower(strings.TrimSpace(l[0])) == key {
+				lines[i] = setting
+				return saveConfig(file, lines)
+			}
+		}
+	}
+
+	if end >= 0 {
+		// add it after the last setting in the profile.
+		lines = append(lines[:end+1], append([]string{setting}, lines[end+1:]...)...)
+	} else if i := sectionIndex(lines, name); i >= 0 {
+		lines = append(lines[:i+1], append([]string{setting}, lines[i+1:]...)...)
+	} else {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+name+"]", setting)
+	}
+	return saveConfig(file, lines)
+}
+
+func sectionIndex(lines []string, name string) int {
+	for i, line := range lines {
+		if s, ok := section(strings.TrimSpace(line)); ok && s == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// saveConfig writes the configuration file out.  It holds secret
+// keys, so only its owner gets to read it.
+func saveConfig(file string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// apply fills in any of the connection options that weren't already
+// set on the command line, or via the environment, from the profile.
+func (p profile) apply() error {
+	for key := range p {
+		if !knownSetting(key) {
+			return fmt.Errorf("unrecognized setting '%s' in profile", key)
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := p[s.Key]
+		if !ok || os.Getenv(s.Env) != "" {
+			continue
+		}
+		switch s.Key {
+		case "aki":
+			if opts.ID == "" {
+				opts.ID = value
+			}
+		case "key":
+			if opts.Key == "" {
+				opts.Key = value
+			}
+		case "session-token":
+			if opts.Token == "" {
+				opts.Token = value
+			}
+		case "assume-role":
+			if opts.AssumeRole == "" {
+				opts.AssumeRole = value
+			}
+		case "external-id":
+			if opts.ExternalID == "" {
+				opts.ExternalID = value
+			}
+		case "role-session-name":
+			if opts.RoleSessionName == "" {
+				opts.RoleSessionName = value
+			}
+		case "mfa-serial":
+			if opts.MFASerial == "" {
+				opts.MFASerial = value
+			}
+		case "sts-url":
+			if opts.STSURL == "" {
+				opts.STSURL = value
+			}
+		case "url":
+			if opts.URL == "" {
+				opts.URL = value
+			}
+		case "region":
+			if opts.Region == "" {
+				opts.Region = value
+			}
+		case "bucket":
+			if opts.Bucket == "" {
+				opts.Bucket = value
+			}
+		case "path-buckets":
+			if !opts.PathBased {
+				opts.PathBased = truthy(value)
+			}
+		case "insecure":
+			if !opts.SkipVerify {
+				opts.SkipVerify = truthy(value)
+			}
+		}
+	}
+	return nil
+}
+
+// loadProfile reads the configuration file, and applies the profile
+// named by --profile / $S3_PROFILE.  If no profile was asked for, the
+// [default] profile is used, if there is one.
+func loadProfile() error {
+	file := configFile()
+	profiles, err := readConfig(file)
+	if err != nil {
+		return err
+	}
+
+	name := opts.Profile
+	if name == "" {
+		name = "default"
+	}
+	p, ok := profiles[name]
+	if !ok {
+		if opts.Profile != "" {
+			return fmt.Errorf("profile '%s' not found in %s", opts.Profile, file)
+		}
+		return nil
+	}
+
+	debugf("using profile @G{%s} from @C{%s}", name, file)
+	if err := p.apply(); err != nil {
+		return fmt.Errorf("%s [%s]: %s", file, name, err)
+	}
+	return nil
+}
+
+func truthy(s string) bool {
+	switch strings.ToLower(s) {
+	case "y", "yes", "1", "true", "on":
+		return true
+	}
+	return false
+}
+
+func sortedProfiles(profiles map[string]profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
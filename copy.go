@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// A location is a key in a bucket.  On the command line, these are
+// either s3://bucket/key URLs, or plain keys in the --bucket bucket.
+type location struct {
+	Bucket string
+	Key    string
+}
+
+func parseLocation(s string) location {
+	if bucket, key, ok := parseS3URL(s); ok {
+		return location{Bucket: bucket, Key: key}
+	}
+	return location{Bucket: opts.Bucket, Key: s}
+}
+
+func (l location) String() string {
+	return fmt.Sprintf("@Y{%s}:@C{%s}", l.Bucket, l.Key)
+}
+
+// A single CopyObject request can only copy objects up to 5GiB;
+// anything bigger has to be copied a part at a time, via a multipart
+// upload.  S3 also caps uploads at 10,000 parts, so for really big
+// objects the parts have to be bigger than copyPartSize.
+const (
+	maxCopySize  = 5 * (1 << 30)
+	copyPartSize = 512 * (1 << 20)
+)
+
+// Headers that describe an object, which S3 carries over on a
+// CopyObject with the COPY metadata directive, but which we have to
+// carry over ourselves when copying via a multipart upload.
+var objectHeaders = []string{
+	"Content-Type",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Expires",
+}
+
+// head retrieves the headers for a key in a bucket, without the body.
+// That includes the object's checksums (see checksum.go), which S3
+// only sends when asked.
+func head(c *s3.Client, bucket, key string) (http.Header, error) {
+	headers := http.Header{}
+	headers.Set("X-Amz-Checksum-Mode", "ENABLED")
+	res, err := request(c, "HEAD", bucket, key, nil, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, responseError(res)
+	}
+	res.Body.Close()
+	return res.Header, nil
+}
+
+// copyObject copies src to dst, server-side, without the data ever
+// passing through this machine.  size is the size of the source
+// object, which decides whether it can be copied in one go or not.
+//
+// directive is the metadata directive: COPY keeps the source
+// object's metadata, REPLACE uses the given headers instead.
+func copyObject(c *s3.Client, src, dst location, size int64, directive string, headers http.Header) error {
+	if size > maxCopySize {
+		return copyMultipart(c, src, dst, size, directive, headers)
+	}
+
+	h := http.Header{}
+	for k, vv := range headers {
+		h[k] = vv
+	}
+	h.Set("X-Amz-Copy-Source", copySource(src.Bucket, src.Key))
+	h.Set("X-Amz-Metadata-Directive", directive)
+
+	res, err := request(c, "PUT", dst.Bucket, dst.Key, nil, h, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return responseError(res)
+	}
+	return readXML(res, &struct{}{})
+}
+
+func copyMultipart(c *s3.Client, src, dst location, size int64, directive string, headers http.Header) error {
+	h := http.Header{}
+	if directive == "COPY" {
+		// a new multipart upload starts out with no metadata,
+		// so we have to go and get it from the source.
+		from, err := head(c, src.Bucket, src.Key)
+		if err != nil {
+			return err
+		}
+		for k, vv := range from {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-meta-") {
+				h[k] = vv
+			}
+		}
+		for _, k := range objectHeaders {
+			if v := from.Get(k); v != "" {
+				h.Set(k, v)
+			}
+		}
+	} else {
+		for k, vv := range headers {
+			h[k] = vv
+		}
+	}
+
+	part := int64(copyPartSize)
+	if min := (size + 9999) / 10000; min > part {
+		part = min
+	}
+	debugf("copying %s (%s) in @W{%d} parts of %s", src, s3.Bytes(size), (size+part-1)/part, s3.Bytes(part))
+
+	m, err := startMultipart(c, dst.Bucket, dst.Key, h, nil)
+	if err != nil {
+		return err
+	}
+	for n, first := 1, int64(0); first < size; n, first = n+1, first+part {
+		last := first + part - 1
+		if last >= size {
+			last = size - 1
+		}
+		debugf("  - copying part @W{%d} (bytes %d-%d)", n, first, last)
+		if err := m.copyPart(n, src.Bucket, src.Key, first, last); err != nil {
+			m.abort()
+			return err
+		}
+	}
+	if err := m.complete(); err != nil {
+		m.abort()
+		return err
+	}
+	return nil
+}
+
+// A copier copies objects from one place to another, server-side,
+// with the same metadata directive (and headers) for each.
+type copier struct {
+	c         *s3.Client
+	directive string
+	headers   http.Header
+}
+
+// one copies a single object, looking up its size first.
+func (cp copier) one(src, dst location) error {
+	h, err := head(cp.c, src.Bucket, src.Key)
+	if err != nil {
+		return fmt.Errorf("%s:%s: %s", src.Bucket, src.Key, err)
+	}
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+
+	fmt.Printf("@G{copy}: %s -> %s\n", src, dst)
+	return copyObject(cp.c, src, dst, size, cp.directive, cp.headers)
+}
+
+// all copies every object under the src prefix to the same relative
+// key under the dst prefix.  Both prefixes should end in a "/", or be
+// empty (for the whole bucket).
+func (cp copier) all(src, dst location) (int, error) {
+	if src.Bucket == dst.Bucket && strings.HasPrefix(dst.Key, src.Key) {
+		return 0, fmt.Errorf("cannot copy %s:%s into itself (%s:%s)", src.Bucket, src.Key, dst.Bucket, dst.Key)
+	}
+
+	n := 0
+	err := newListing(src.Bucket, src.Key).eachObject(cp.c, func(o s3.Object) error {
+		from := location{Bucket: src.Bucket, Key: o.Key}
+		to := location{Bucket: dst.Bucket, Key: dst.Key + strings.TrimPrefix(o.Key, src.Key)}
+
+		fmt.Printf("@G{copy}: %s -> %s\n", from, to)
+		if err := copyObject(cp.c, from, to, int64(o.Size), cp.directive, cp.headers); err != nil {
+			return fmt.Errorf("%s:%s: %s", from.Bucket, from.Key, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// metadataDirective validates a --metadata-directive value.
+func metadataDirective(s string) (string, error) {
+	switch d := strings.ToUpper(s); d {
+	case "COPY", "REPLACE":
+		return d, nil
+	}
+	return "", fmt.Errorf("invalid --metadata-directive '%s' (must be COPY or REPLACE)", s)
+}
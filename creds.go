@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// credentials are what we sign requests with.  Temporary credentials
+// (from an instance role, for example) come with a session token, and
+// an expiry time; static keys never expire.
+type credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expires         time.Time
+}
+
+// A provider is one link in the credential chain.  It returns ok=false
+// if it has nothing to offer (i.e. the environment variables it looks
+// at aren't set), so that the next one can be tried; errors are for
+// when it should have been able to supply credentials, but couldn't.
+type provider struct {
+	Name  string
+	Fetch func() (credentials, bool, error)
+}
+
+// chain lists the places we look for credentials, in order, if none
+// were given via --aki / --key (or the profile).  This is the same
+// order the AWS CLI and SDKs use.
+var chain = []provider{
+	{"environment", envCredentials},
+	{"shared credentials / config files", sharedCredentials},
+	{"ECS container endpoint", ecsCredentials},
+	{"EC2 instance metadata", imdsCredentials},
+}
+
+// findCredentials walks the credential chain, returning the first
+// set of credentials found, along with the provider they came from.
+func findCredentials() (credentials, provider, error) {
+	for _, p := range chain {
+		debugf("looking for credentials in the @C{%s}", p.Name)
+		cr, ok, err := p.Fetch()
+		if err != nil {
+			return credentials{}, p, fmt.Errorf("unable to get credentials from the %s: %s", p.Name, err)
+		}
+		if ok {
+			debugf("using credentials from the @G{%s}", p.Name)
+			return cr, p, nil
+		}
+	}
+	return credentials{}, provider{}, fmt.Errorf("missing required --aki / --key (or $S3_AKI / $S3_KEY) values, and no AWS credentials were found")
+}
+
+// The credentials we sign requests with are kept here, and not in
+// the s3.Client, because keepFresh swaps them out from under the rest
+// of the program.  go-s3 reads them straight out of the client, with
+// no locking, so the client that client() hands out is never changed;
+// go-s3 only ever sees a copy of it, made by signer().
+var (
+	credsLock sync.RWMutex
+	creds     credentials
+)
+
+func setCredentials(cr credentials) {
+	credsLock.Lock()
+	defer credsLock.Unlock()
+	creds = cr
+	secret(cr.SecretAccessKey)
+	secret(cr.Token)
+}
+
+func currentCredentials() credentials {
+	credsLock.RLock()
+	defer credsLock.RUnlock()
+	return creds
+}
+
+// signer returns a copy of the client, with the current credentials,
+// for go-s3 to sign its requests with.
+func signer(c *s3.Client) *s3.Client {
+	cr := currentCredentials()
+	cp := *c
+	cp.AccessKeyID = cr.AccessKeyID
+	cp.SecretAccessKey = cr.SecretAccessKey
+	cp.Token = cr.Token
+	return &cp
+}
+
+// keepFresh re-fetches credentials from the provider a few minutes
+// before they expire, for as long as the program runs, so that long
+// uploads, downloads and syncs don't fall over half-way through.
+func keepFresh(p provider, cr credentials) {
+	if cr.Expires.IsZero() {
+		return
+	}
+	go func() {
+		for {
+			wait := time.Until(cr.Expires) - 5*time.Minute
+			if wait < 10*time.Second {
+				wait = 10 * time.Second
+			}
+			time.Sleep(wait)
+
+			next, ok, err := p.Fetch()
+			if err != nil || !ok {
+				warnf("unable to refresh credentials from the %s: %v", p.Name, err)
+				continue
+			}
+			setCredentials(next)
+			if next.Expires.IsZero() {
+				debugf("refreshed credentials from the @G{%s} (good indefinitely)", p.Name)
+				return
+			}
+			debugf("refreshed credentials from the @G{%s} (good until %s)", p.Name, next.Expires)
+			cr = next
+		}
+	}()
+}
+
+func envCredentials() (credentials, bool, error) {
+	cr := credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:           os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cr.AccessKeyID == "" || cr.SecretAccessKey == "" {
+		return cr, false, nil
+	}
+	return cr, true, nil
+}
+
+// awsProfile returns the settings for the $AWS_PROFILE profile (or
+// the default one), merged from ~/.aws/credentials and ~/.aws/config.
+// In the config file, profiles other than the default are named
+// [profile NAME]; in the credentials file, they're just [NAME].
+func awsProfile() (profile, error) {
+	home, _ := os.UserHomeDir()
+	name := os.Getenv("AWS_PROFILE")
+	if name == "" {
+		name = "default"
+	}
+
+	merged := make(profile)
+	for _, f := range []struct {
+		env, file, section string
+	}{
+		{"AWS_CONFIG_FILE", "config", "profile " + name},
+		{"AWS_SHARED_CREDENTIALS_FILE", "credentials", name},
+	} {
+		file := os.Getenv(f.env)
+		if file == "" {
+			file = filepath.Join(home, ".aws", f.file)
+		}
+		profiles, err := readConfig(file)
+		if err != nil {
+			return nil, err
+		}
+
+		section := f.section
+		if name == "default" {
+			section = "default"
+		}
+		for k, v := range profiles[section] {
+			merged[k] = v
+		}
+	}
+	return merged, nil
+}
+
+func sharedCredentials() (credentials, bool, error) {
+	p, err := awsProfile()
+	if err != nil {
+		return credentials{}, false, err
+	}
+
+	if p["aws_access_key_id"] != "" && p["aws_secret_access_key"] != "" {
+		return credentials{
+			AccessKeyID:     p["aws_access_key_id"],
+			SecretAccessKey: p["aws_secret_access_key"],
+			Token:           p["aws_session_token"],
+		}, true, nil
+	}
+	if cmd := p["credential_process"]; cmd != "" {
+		cr, err := credentialProcess(cmd)
+		return cr, err == nil, err
+	}
+	return credentials{}, false, nil
+}
+
+// credentialProcess runs an external helper program that prints out
+// credentials as JSON, as described in "Sourcing credentials with an
+// external process" in the AWS CLI docs.
+func credentialProcess(command string) (credentials, error) {
+	debugf("running credential_process @C{%s}", command)
+	var stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return credentials{}, fmt.Errorf("credential_process failed: %s %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var r struct {
+		Version         int
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(out, &r); err != nil {
+		return credentials{}, fmt.Errorf("credential_process: %s", err)
+	}
+	if r.Version != 1 {
+		return credentials{}, fmt.Errorf("credential_process: unsupported Version %d", r.Version)
+	}
+	if r.AccessKeyID == "" || r.SecretAccessKey == "" {
+		return credentials{}, fmt.Errorf("credential_process: missing AccessKeyId / SecretAccessKey")
+	}
+	return credentials{
+		AccessKeyID:     r.AccessKeyID,
+		SecretAccessKey: r.SecretAccessKey,
+		Token:           r.SessionToken,
+		Expires:         r.Expiration,
+	}, nil
+}
+
+// metadata is the HTTP client used to talk to the ECS and EC2
+// metadata endpoints.  These are link-local, so they should answer
+// quickly (if at all), and must never go through a proxy.
+var metadata = &http.Client{
+	Timeout:   time.Second,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+// roleCredentials parses the JSON credentials document that both the
+// ECS and EC2 metadata endpoints hand out.
+func roleCredentials(res *http.Response) (credentials, error) {
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return credentials{}, fmt.Errorf("%s", res.Status)
+	}
+
+	var r struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return credentials{}, err
+	}
+	return credentials{
+		AccessKeyID:     r.AccessKeyID,
+		SecretAccessKey: r.SecretAccessKey,
+		Token:           r.Token,
+		Expires:         r.Expiration,
+	}, nil
+}
+
+func ecsCredentials() (credentials, bool, error) {
+	uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		uri = "http://169.254.170.2" + rel
+	}
+	if uri == "" {
+		return credentials{}, false, nil
+	}
+
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return credentials{}, false, err
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return credentials{}, false, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	res, err := metadata.Do(req)
+	if err != nil {
+		return credentials{}, false, err
+	}
+	cr, err := roleCredentials(res)
+	return cr, err == nil, err
+}
+
+// imdsCredentials gets the instance role's credentials from the EC2
+// instance metadata service, using IMDSv2 (session tokens), which
+// newer instances insist on.  If there's no metadata service (i.e.
+// we're not on EC2), there are no credentials to be had here.
+func imdsCredentials() (credentials, bool, error) {
+	if strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) == "true" {
+		return credentials{}, false, nil
+	}
+	base := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if base == "" {
+		base = "http://169.254.169.254"
+	}
+	base = strings.TrimSuffix(base, "/")
+
+	req, _ := http.NewRequest("PUT", base+"/latest/api/token", nil)
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	res, err := metadata.Do(req)
+	if err != nil {
+		debugf("  - no instance metadata service (%s)", err)
+		return credentials{}, false, nil
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		return credentials{}, false, fmt.Errorf("unable to get an IMDSv2 token: %s", res.Status)
+	}
+	token := string(b)
+
+	get := func(path string) (*http.Response, error) {
+		req, _ := http.NewRequest("GET", base+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		return metadata.Do(req)
+	}
+
+	res, err = get("")
+	if err != nil {
+		return credentials{}, false, err
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode == 404 {
+		debugf("  - this instance has no IAM role")
+		return credentials{}, false, nil
+	}
+	if res.StatusCode != 200 {
+		return credentials{}, false, fmt.Errorf("unable to determine instance role: %s", res.Status)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+
+	res, err = get(role)
+	if err != nil {
+		return credentials{}, false, err
+	}
+	cr, err := roleCredentials(res)
+	return cr, err == nil, err
+}
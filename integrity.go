@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// Every part of every upload is sent with a Content-MD5 header, so
+// that S3 can refuse anything that got mangled on the way over.  We
+// also work out what the ETag of the finished object ought to be,
+// and check it against what S3 says it is.
+//
+// For objects uploaded in a single PUT, the ETag is the MD5 of the
+// object's contents.  For multipart uploads, it is the MD5 of the
+// (binary) MD5s of each part, followed by a dash and the number of
+// parts, which is of no use for checking a download, since it
+// depends on how big the parts were.  So `put` also stores the MD5
+// of the whole file in x-amz-meta-md5chksum (the same place rclone
+// puts it), for `get` to check against.
+//
+// Objects encrypted with SSE-KMS or SSE-C have ETags that aren't
+// MD5s of anything we can compute, so they can only be checked via
+// the metadata.
+
+// md5Meta is the metadata key holding the MD5 of the whole object,
+// base64-encoded.
+const md5Meta = "md5chksum"
+
+var plainETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+var multipartETagRE = regexp.MustCompile(`^[0-9a-f]{32}-([0-9]+)$`)
+
+// opaqueETag says whether an object's ETag has nothing to do with
+// the MD5 of its contents, because of how it was encrypted.
+func (info objectInfo) opaqueETag() bool {
+	return info.SSE == "aws:kms" || info.SSECustomerAlgorithm != ""
+}
+
+// md5 returns the MD5 of the contents of the object, if we can know
+// it without downloading it: either from its ETag, or from the
+// metadata that `put` leaves behind.  Otherwise, md5 returns nil.
+func (info objectInfo) md5() []byte {
+	if plainETag.MatchString(info.ETag) && !info.opaqueETag() {
+		sum, _ := hex.DecodeString(info.ETag)
+		return sum
+	}
+	if v, ok := info.Metadata[md5Meta]; ok {
+		if sum, err := base64.StdEncoding.DecodeString(v); err == nil && len(sum) == md5.Size {
+			return sum
+		}
+	}
+	return nil
+}
+
+// multipartETag computes the ETag that S3 gives to an object made up
+// of parts with the given MD5s.
+func multipartETag(sums [][]byte) string {
+	h := md5.New()
+	for _, sum := range sums {
+		h.Write(sum)
+	}
+	return fmt.Sprintf("%x-%d", h.Sum(nil), len(sums))
+}
+
+// md5Of hashes n bytes of a file, starting from offset.
+func md5Of(f *os.File, offset, n int64) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, n)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// wholeFileSums hashes a file, for storing its MD5 (and its checksum,
+// if given a checksum algorithm) in the metadata of the object it is
+// being uploaded to, unless the metadata headers already have them.
+func wholeFileSums(f *os.File, size int64, headers http.Header, a *checksumAlgorithm) error {
+	var hashes []io.Writer
+	h := md5.New()
+	if headers.Get("X-Amz-Meta-"+md5Meta) == "" {
+		hashes = append(hashes, h)
+	}
+	var sum hash.Hash
+	if a != nil && headers.Get("X-Amz-Meta-"+a.meta()) == "" {
+		sum = a.new()
+		hashes = append(hashes, sum)
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	if _, err := io.Copy(io.MultiWriter(hashes...), io.NewSectionReader(f, 0, size)); err != nil {
+		return err
+	}
+	if headers.Get("X-Amz-Meta-"+md5Meta) == "" {
+		headers.Set("X-Amz-Meta-"+md5Meta, base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+	if sum != nil {
+		headers.Set("X-Amz-Meta-"+a.meta(), base64.StdEncoding.EncodeToString(sum.Sum(nil)))
+	}
+	return nil
+}
+
+// fileETag computes the ETag that a file would have, if it were
+// uploaded in parts of the given size.
+func fileETag(f *os.File, size, partSize int64) (string, error) {
+	var sums [][]byte
+	for offset := int64(0); offset < size; offset += partSize {
+		sum, err := md5Of(f, offset, min64(partSize, size-offset))
+		if err != nil {
+			return "", err
+		}
+		sums = append(sums, sum)
+	}
+	return multipartETag(sums), nil
+}
+
+// A downloadCheck hashes a download as it goes by, for checking
+// against the MD5 and / or the checksum (see checksum.go) that the
+// object says it should have, if it says anything at all.
+type downloadCheck struct {
+	info objectInfo
+
+	md5  hash.Hash
+	alg  *checksumAlgorithm
+	want string
+	sum  hash.Hash
+}
+
+func newDownloadCheck(info objectInfo) *downloadCheck {
+	d := &downloadCheck{info: info, md5: md5.New()}
+	if d.alg, d.want = info.checksum(); d.alg != nil {
+		d.sum = d.alg.new()
+	}
+	return d
+}
+
+func (d *downloadCheck) Write(b []byte) (int, error) {
+	d.md5.Write(b)
+	if d.sum != nil {
+		d.sum.Write(b)
+	}
+	return len(b), nil
+}
+
+// check checks everything written so far against what the object
+// says it should be.
+func (d *downloadCheck) check() error {
+	what := fmt.Sprintf("%s:%s", d.info.Bucket, d.info.Key)
+	checked := false
+
+	if want := d.info.md5(); want != nil {
+		if got := d.md5.Sum(nil); string(got) != string(want) {
+			return fmt.Errorf("%s was corrupted on the way down: its MD5 should be %x, but what we got has an MD5 of %x", what, want, got)
+		}
+		debugf("verified the MD5 of @Y{%s}:@C{%s}", d.info.Bucket, d.info.Key)
+		checked = true
+	}
+	if d.sum != nil {
+		if got := base64.StdEncoding.EncodeToString(d.sum.Sum(nil)); got != d.want {
+			return fmt.Errorf("%s was corrupted on the way down: its %s checksum should be %s, but what we got has a checksum of %s", what, d.alg, d.want, got)
+		}
+		debugf("verified the %s checksum of @Y{%s}:@C{%s}", d.alg, d.info.Bucket, d.info.Key)
+		checked = true
+	}
+
+	if !checked {
+		debugf("unable to verify the download of @Y{%s}:@C{%s}: it has neither an MD5 nor a checksum of its contents", d.info.Bucket, d.info.Key)
+	}
+	return nil
+}
+
+// checkFile checks a downloaded file against what the object says
+// its MD5 and / or checksum should be.
+func checkFile(info objectInfo, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d := newDownloadCheck(info)
+	if _, err := io.Copy(d, f); err != nil {
+		return err
+	}
+	return d.check()
+}
+
+// verification outcomes, for `s3 verify`.
+const (
+	verifiedSame = iota
+	verifiedDifferent
+	verifiedUnknown
+)
+
+// verify compares a local file with an object in S3, without
+// downloading the object.  Sizes are compared first; then MD5s, or,
+// for multipart objects, the ETag that the file would have if it
+// were uploaded with the same part size as the object.  verify
+// returns one of the verification outcomes, and why.
+func verify(c *s3.Client, path, bucket, key string) (int, string, error) {
+	info, err := stat(c, bucket, key)
+	if err != nil {
+		return verifiedUnknown, "", err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return verifiedUnknown, "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return verifiedUnknown, "", err
+	}
+
+	size := fi.Size()
+	if size != info.ContentLength {
+		return verifiedDifferent, fmt.Sprintf("sizes differ: %s is %d bytes, %s:%s is %d bytes", path, size, bucket, key, info.ContentLength), nil
+	}
+
+	if want := info.md5(); want != nil {
+		got, err := md5Of(f, 0, size)
+		if err != nil {
+			return verifiedUnknown, "", err
+		}
+		if string(got) != string(want) {
+			return verifiedDifferent, fmt.Sprintf("MD5s differ: %s is %x, %s:%s is %x", path, got, bucket, key, want), nil
+		}
+		return verifiedSame, fmt.Sprintf("MD5 %x", got), nil
+	}
+
+	m := multipartETagRE.FindStringSubmatch(info.ETag)
+	if m == nil || info.opaqueETag() {
+		return verifiedUnknown, fmt.Sprintf("%s:%s has no MD5 to compare against (its ETag is %s)", bucket, key, info.ETag), nil
+	}
+	parts, _ := strconv.Atoi(m[1])
+
+	for _, partSize := range guessPartSizes(c, bucket, key, size, parts) {
+		etag, err := fileETag(f, size, partSize)
+		if err != nil {
+			return verifiedUnknown, "", err
+		}
+		debugf("with @W{%s} parts, @C{%s} would have an ETag of @W{%s}", s3.Bytes(partSize), path, etag)
+		if etag == info.ETag {
+			return verifiedSame, fmt.Sprintf("ETag %s, with %s parts", etag, s3.Bytes(partSize)), nil
+		}
+	}
+	return verifiedDifferent, fmt.Sprintf("ETags differ: %s:%s is %s, and %s doesn't match that with any likely part size", bucket, key, info.ETag, path), nil
+}
+
+// guessPartSizes comes up with the part sizes that an object of the
+// given size, in the given number of parts, was likely uploaded with.
+// S3 will tell us how big the first part was (via ?partNumber=1),
+// but not every S3 work-alike will, so we also try the sizes that
+// we (and other popular tools) would have picked.
+func guessPartSizes(c *s3.Client, bucket, key string, size int64, parts int) []int64 {
+	var sizes []int64
+	try := func(partSize int64) {
+		if partSize <= 0 || (size+partSize-1)/partSize != int64(parts) {
+			return
+		}
+		for _, s := range sizes {
+			if s == partSize {
+				return
+			}
+		}
+		sizes = append(sizes, partSize)
+	}
+
+	res, err := request(c, "HEAD", bucket, key, url.Values{"partNumber": {"1"}}, nil, nil)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode == 200 || res.StatusCode == 206 {
+			n, _ := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+			try(n)
+		}
+	}
+
+	try(filePartSize(size, 0))
+	for _, mib := range []int64{5, 8, 15, 16, 32, 64, 100, 128, 256, 512, 1024} {
+		try(mib << 20)
+	}
+
+	// and, failing all that, the smallest whole number of MiB.
+	if parts > 0 {
+		try((size/int64(parts) + (1 << 20) - 1) / (1 << 20) * (1 << 20))
+	}
+	return sizes
+}
+
+// hexETag tidies up an ETag (as given in a response header, or an XML
+// document) for comparing with one we computed.
+func hexETag(etag string) string {
+	return strings.ToLower(strings.Trim(etag, `"`))
+}
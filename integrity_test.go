@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/md5"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The expected ETags here were worked out independently: the MD5 of
+// the (binary) MD5s of the parts, a dash, and the number of parts.
+var etagTests = []struct {
+	name     string
+	data     []byte
+	partSize int64
+	etag     string
+}{
+	{"single part", []byte("hello world"), 5 * mib, "241d8a27c836427bd7f04461b60e7359-1"},
+	{"equal parts", []byte("abcdefghijkl"), 4, "17ca064a842163311e72510a0a5e810c-3"},
+	{"short last part", []byte("abcdefghijkl"), 5, "820f43a9e133258e67cab5ff59842a92-3"},
+	{"11MiB in 5MiB parts", make([]byte, 11*mib), 5 * mib, "e3bc5f891b51a71011bfcec5583ace3c-3"},
+}
+
+func TestMultipartETag(t *testing.T) {
+	for _, test := range etagTests {
+		var sums [][]byte
+		for offset := int64(0); offset < int64(len(test.data)); offset += test.partSize {
+			end := min64(offset+test.partSize, int64(len(test.data)))
+			sum := md5.Sum(test.data[offset:end])
+			sums = append(sums, sum[:])
+		}
+		if got := multipartETag(sums); got != test.etag {
+			t.Errorf("%s: multipartETag() = %s, wanted %s", test.name, got, test.etag)
+		}
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	for _, test := range etagTests {
+		f, err := ioutil.TempFile("", "s3-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := f.Write(test.data); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := fileETag(f, int64(len(test.data)), test.partSize)
+		if err != nil {
+			t.Errorf("%s: fileETag() failed: %s", test.name, err)
+		} else if got != test.etag {
+			t.Errorf("%s: fileETag() = %s, wanted %s", test.name, got, test.etag)
+		}
+	}
+}
+
+func TestDownloadCheck(t *testing.T) {
+	const (
+		helloMD5    = "5eb63bbbe01eeed093cb22bb8f5acdc3"
+		helloMD5b64 = "XrY7u+Ae7tCTyyK7j1rNww=="
+		helloSHA256 = "uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek="
+		helloCRC32  = "DUoRhQ=="
+	)
+
+	tests := []struct {
+		name    string
+		info    objectInfo
+		corrupt bool // should a corrupted download be caught?
+	}{
+		{
+			name:    "plain ETag",
+			info:    objectInfo{ETag: helloMD5},
+			corrupt: true,
+		},
+		{
+			name:    "multipart ETag, with an MD5 in the metadata",
+			info:    objectInfo{ETag: "241d8a27c836427bd7f04461b60e7359-1", Metadata: map[string]string{md5Meta: helloMD5b64}},
+			corrupt: true,
+		},
+		{
+			name: "multipart ETag, without an MD5 in the metadata",
+			info: objectInfo{ETag: "241d8a27c836427bd7f04461b60e7359-1"},
+		},
+		{
+			name: "SSE-KMS, whose ETag isn't an MD5",
+			info: objectInfo{ETag: "0123456789abcdef0123456789abcdef", SSE: "aws:kms"},
+		},
+		{
+			name:    "SHA256 checksum from S3",
+			info:    objectInfo{ETag: "241d8a27c836427bd7f04461b60e7359-1", Checksums: map[string]string{"sha256": helloSHA256}},
+			corrupt: true,
+		},
+		{
+			name:    "CRC32 checksum in the metadata",
+			info:    objectInfo{ETag: "241d8a27c836427bd7f04461b60e7359-1", Metadata: map[string]string{"crc32chksum": helloCRC32}},
+			corrupt: true,
+		},
+		{
+			name: "composite checksum from S3",
+			info: objectInfo{ETag: "241d8a27c836427bd7f04461b60e7359-1", Checksums: map[string]string{"sha256": "nothing+we+can+check+against=-1"}},
+		},
+	}
+
+	for _, test := range tests {
+		d := newDownloadCheck(test.info)
+		if _, err := d.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.check(); err != nil {
+			t.Errorf("%s: a good download failed its check: %s", test.name, err)
+		}
+
+		d = newDownloadCheck(test.info)
+		d.Write([]byte("hello "))
+		d.Write([]byte("w0rld"))
+		err := d.check()
+		if test.corrupt && (err == nil || !strings.Contains(err.Error(), "corrupted")) {
+			t.Errorf("%s: a corrupted download wasn't caught (got %v)", test.name, err)
+		}
+		if !test.corrupt && err != nil {
+			t.Errorf("%s: a download with nothing to check it against failed its check: %s", test.name, err)
+		}
+	}
+}
+
+func TestCheckFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "s3-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write([]byte("hello world"))
+	f.Close()
+
+	if err := checkFile(objectInfo{ETag: "5eb63bbbe01eeed093cb22bb8f5acdc3"}, f.Name()); err != nil {
+		t.Errorf("a good file failed its check: %s", err)
+	}
+	if err := checkFile(objectInfo{ETag: "6eb63bbbe01eeed093cb22bb8f5acdc3"}, f.Name()); err == nil {
+		t.Errorf("a corrupted file wasn't caught")
+	}
+}
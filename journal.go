@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// An uploadJournal records the progress of a multipart upload of a
+// local file: which upload it is, what was being uploaded, and which
+// parts have made it to S3.  If `put` is interrupted, the journal
+// (and the parts already uploaded) are left behind, so that
+// `put --resume` can pick up where it left off.
+type uploadJournal struct {
+	Endpoint string            `json:"endpoint"`
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	UploadID string            `json:"upload_id"`
+	Source   fingerprint       `json:"source"`
+	PartSize int64             `json:"part_size"`
+	Checksum string            `json:"checksum,omitempty"`
+	Parts    map[int]savedPart `json:"parts"`
+
+	file string
+	lock sync.Mutex
+}
+
+// A fingerprint identifies the local file being uploaded, so that
+// we don't resume an upload of a file that has since changed.
+type fingerprint struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func fingerprintOf(path string, info os.FileInfo) fingerprint {
+	return fingerprint{Path: path, Size: info.Size(), ModTime: info.ModTime().UTC()}
+}
+
+func (f fingerprint) same(other fingerprint) bool {
+	return f.Path == other.Path && f.Size == other.Size && f.ModTime.Equal(other.ModTime)
+}
+
+type savedPart struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// journalFile names the journal for uploading a local file to a key
+// in a bucket.
+func journalFile(c *s3.Client, bucket, key, path string) string {
+	return stateFile("uploads", endpoint(c, bucket, "", nil).Host, bucket, key, path)
+}
+
+// stateFile names a file in $XDG_STATE_HOME/s3/KIND (or under
+// ~/.local/state, if that isn't set), after a hash of the things
+// that identify it.
+func stateFile(kind string, ids ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "s3", kind, hex.EncodeToString(sum[:16])+".json")
+}
+
+// loadJournal reads a journal back in.  If there isn't one, there's
+// nothing to resume, and loadJournal returns nil (without error).
+func loadJournal(file string) (*uploadJournal, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	j := &uploadJournal{file: file}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if j.Parts == nil {
+		j.Parts = make(map[int]savedPart)
+	}
+	return j, nil
+}
+
+// save writes the journal out.  It goes to a temporary file first,
+// which is then renamed into place, so that getting killed half-way
+// through a write doesn't leave us with a corrupt journal.
+func (j *uploadJournal) save() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.file), 0700); err != nil {
+		return err
+	}
+	tmp := j.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.file)
+}
+
+// has says whether part n has already been uploaded.
+func (j *uploadJournal) has(n int) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	_, ok := j.Parts[n]
+	return ok
+}
+
+// record notes that part n has been uploaded.
+func (j *uploadJournal) record(n int, etag string, size int64) error {
+	j.lock.Lock()
+	j.Parts[n] = savedPart{ETag: etag, Size: size}
+	j.lock.Unlock()
+	return j.save()
+}
+
+// remove deletes the journal, once the upload is complete (or has
+// been given up on).
+func (j *uploadJournal) remove() {
+	if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		warnf("unable to remove upload journal %s: %s", j.file, err)
+	}
+}
+
+// A downloadJournal records which object (and which version of it,
+// by ETag) a local file is being downloaded from, so that `get
+// --continue` can safely pick up a partial download where it left
+// off, without splicing together two different versions.
+//
+// Downloads that are fetched in parallel parts (see ranged.go) also
+// record how big the object is, how big its parts are, and which of
+// them have been written to the file.
+type downloadJournal struct {
+	Endpoint string       `json:"endpoint"`
+	Bucket   string       `json:"bucket"`
+	Key      string       `json:"key"`
+	ETag     string       `json:"etag"`
+	Size     int64        `json:"size,omitempty"`
+	PartSize int64        `json:"part_size,omitempty"`
+	Parts    map[int]bool `json:"parts,omitempty"`
+
+	file string
+	lock sync.Mutex
+}
+
+// downloadJournalFor returns the (possibly empty) journal for a
+// download to a local file.  Downloads are journaled by local path,
+// since there can only be one download into a given file at a time.
+func downloadJournalFor(path string) (*downloadJournal, error) {
+	j := &downloadJournal{file: stateFile("downloads", path)}
+	b, err := ioutil.ReadFile(j.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("%s: %s", j.file, err)
+	}
+	return j, nil
+}
+
+func (j *downloadJournal) save() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.file), 0700); err != nil {
+		return err
+	}
+	tmp := j.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.file)
+}
+
+// has says whether part n has already been downloaded.
+func (j *downloadJournal) has(n int) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.Parts[n]
+}
+
+// record notes that part n has been downloaded.
+func (j *downloadJournal) record(n int) error {
+	j.lock.Lock()
+	j.Parts[n] = true
+	j.lock.Unlock()
+	return j.save()
+}
+
+func (j *downloadJournal) remove() {
+	if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		warnf("unable to remove download journal %s: %s", j.file, err)
+	}
+}
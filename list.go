@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// A listing asks for (part of) the contents of a bucket, via
+// the ListObjectsV2 API.  Unlike go-s3's List(), it lets S3
+// do the filtering by Prefix, and can roll keys up into
+// "directories" (common prefixes) by Delimiter.
+//
+// Listings are consumed a page at a time, so that we never
+// have to hold the whole bucket in memory, and so that callers
+// can start working before the last page has been fetched.
+type listing struct {
+	Bucket    string
+	Prefix    string
+	Delimiter string
+
+	StartAfter        string
+	ContinuationToken string
+	MaxKeys           int
+}
+
+// A page is one response from ListObjectsV2.  Objects and
+// Prefixes are each sorted by key, but not merged.
+type page struct {
+	Objects  []s3.Object
+	Prefixes []string
+}
+
+// newListing builds a listing for the given bucket and prefix,
+// picking up the --max-keys, --start-after and --continuation-token
+// options that all of the list-driven commands honor.
+func newListing(bucket, prefix string) listing {
+	return listing{
+		Bucket:            bucket,
+		Prefix:            prefix,
+		StartAfter:        opts.StartAfter,
+		ContinuationToken: opts.ContinuationToken,
+		MaxKeys:           opts.MaxKeys,
+	}
+}
+
+// errInterrupted means that a walk was cut short by an interrupt
+// (^C).  By then, how to pick it back up has already been printed.
+var errInterrupted = fmt.Errorf("interrupted")
+
+var (
+	interrupted     = make(chan struct{})
+	interruptedOnce sync.Once
+)
+
+// stopping says whether we've been interrupted, and so should stop
+// what we're doing (after finishing up anything half-done, like a
+// half-written file).
+func stopping() bool {
+	select {
+	case <-interrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// walk fetches the listing, one page at a time, handing each page
+// to fn as soon as it arrives.  If fn returns an error, the walk
+// stops and returns that error.
+//
+// While a walk is in progress, an interrupt (^C) prints the
+// continuation token for the page being processed, so that the
+// operation can be picked up again with --continuation-token, and
+// the walk stops, returning errInterrupted, once fn is done with
+// that page.  (A second ^C kills s3 outright.)
+func (l listing) walk(c *s3.Client, fn func(page) error) error {
+	var (
+		lock sync.Mutex
+		ctok = l.ContinuationToken
+	)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer func() {
+		signal.Stop(sig)
+		close(sig)
+	}()
+	go func() {
+		if _, ok := <-sig; !ok {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		signal.Stop(sig)
+		if ctok != "" {
+			fmt.Fprintf(os.Stderr, "\n@Y{interrupted}; to resume, re-run with @W{--continuation-token} @C{%s}\n", ctok)
+		} else {
+			fmt.Fprintf(os.Stderr, "\n@Y{interrupted}; to resume, re-run the same command.\n")
+		}
+		interruptedOnce.Do(func() { close(interrupted) })
+	}()
+
+	left := l.MaxKeys
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("fetch-owner", "true")
+		if l.Prefix != "" {
+			q.Set("prefix", l.Prefix)
+		}
+		if l.Delimiter != "" {
+			q.Set("delimiter", l.Delimiter)
+		}
+		if l.StartAfter != "" {
+			q.Set("start-after", l.StartAfter)
+		}
+		if left > 0 && left < 1000 {
+			q.Set("max-keys", strconv.Itoa(left))
+		}
+		lock.Lock()
+		if ctok != "" {
+			q.Set("continuation-token", ctok)
+		}
+		lock.Unlock()
+
+		p, next, err := l.fetch(c, q)
+		if err != nil {
+			return err
+		}
+		if stopping() {
+			return errInterrupted
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+		if stopping() {
+			return errInterrupted
+		}
+
+		if left > 0 {
+			left -= len(p.Objects) + len(p.Prefixes)
+			if left <= 0 {
+				if next != "" {
+					fmt.Fprintf(os.Stderr, "@Y{stopped after %d keys}; to continue, re-run with @W{--continuation-token} @C{%s}\n", l.MaxKeys, next)
+				}
+				return nil
+			}
+		}
+		if next == "" {
+			return nil
+		}
+
+		lock.Lock()
+		ctok = next
+		lock.Unlock()
+	}
+}
+
+// fetch retrieves a single page of the listing, returning it along
+// with the continuation token for the next page ("" if this was
+// the last one).
+func (l listing) fetch(c *s3.Client, q url.Values) (page, string, error) {
+	res, err := request(c, "GET", l.Bucket, "", q, nil, nil)
+	if err != nil {
+		return page{}, "", err
+	}
+	if res.StatusCode != 200 {
+		return page{}, "", responseError(res)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return page{}, "", err
+	}
+
+	var r struct {
+		XMLName   xml.Name `xml:"ListBucketResult"`
+		Truncated bool     `xml:"IsTruncated"`
+		Next      string   `xml:"NextContinuationToken"`
+		Contents  []struct {
+			Key          string `xml:"Key"`
+			LastModified string `xml:"LastModified"`
+			ETag         string `xml:"ETag"`
+			Size         int64  `xml:"Size"`
+			StorageClass string `xml:"StorageClass"`
+			Owner        struct {
+				ID          string `xml:"ID"`
+				DisplayName string `xml:"DisplayName"`
+			} `xml:"Owner"`
+		} `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+	}
+	if err := xml.Unmarshal(b, &r); err != nil {
+		return page{}, "", err
+	}
+
+	var p page
+	for _, f := range r.Contents {
+		mod, _ := time.Parse(time.RFC3339, f.LastModified)
+		p.Objects = append(p.Objects, s3.Object{
+			Key:          f.Key,
+			LastModified: mod,
+			ETag:         strings.Trim(f.ETag, `"`),
+			Size:         s3.Bytes(f.Size),
+			StorageClass: f.StorageClass,
+			OwnerID:      f.Owner.ID,
+			OwnerName:    f.Owner.DisplayName,
+		})
+	}
+	for _, cp := range r.CommonPrefixes {
+		p.Prefixes = append(p.Prefixes, cp.Prefix)
+	}
+
+	if !r.Truncated {
+		return p, "", nil
+	}
+	return p, r.Next, nil
+}
+
+// whole says whether a walk of the listing that turned up `seen`
+// keys covered everything under its prefix, and wasn't cut short by
+// --max-keys, or started partway through by --start-after.  (Walks
+// resumed via --continuation-token pick up where an interrupted one
+// left off, and so count as whole.)
+func (l listing) whole(seen int) bool {
+	return l.StartAfter == "" && (l.MaxKeys <= 0 || seen < l.MaxKeys)
+}
+
+// eachObject walks the listing, calling fn for every object (but
+// not every common prefix) in it.
+func (l listing) eachObject(c *s3.Client, fn func(s3.Object) error) error {
+	return l.walk(c, func(p page) error {
+		for _, f := range p.Objects {
+			if stopping() {
+				return errInterrupted
+			}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jhunt/go-s3"
+)
+
+// fakeBucket serves ListObjectsV2 for a bucket with the given keys,
+// at most `pageSize` of them to a page, using the last key of each
+// page as the continuation token.  It remembers the query of every
+// request it gets.
+type fakeBucket struct {
+	keys     []string
+	pageSize int
+
+	lock     sync.Mutex
+	requests []url.Values
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	b.lock.Lock()
+	b.requests = append(b.requests, q)
+	b.lock.Unlock()
+
+	if r.URL.Path != "/bucket/" || q.Get("list-type") != "2" {
+		http.Error(w, "not a ListObjectsV2 request", 400)
+		return
+	}
+
+	max := b.pageSize
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n < max {
+		max = n
+	}
+	after := q.Get("start-after")
+	if tok := q.Get("continuation-token"); tok != "" {
+		after = tok
+	}
+
+	var keys []string
+	for _, k := range b.keys {
+		if k > after && strings.HasPrefix(k, q.Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	truncated := len(keys) > max
+	if truncated {
+		keys = keys[:max]
+	}
+
+	fmt.Fprintf(w, "<ListBucketResult>")
+	for _, k := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><ETag>&quot;d41d8cd98f00b204e9800998ecf8427e&quot;</ETag><Size>0</Size></Contents>", k)
+	}
+	fmt.Fprintf(w, "<IsTruncated>%v</IsTruncated>", truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprintf(w, "</ListBucketResult>")
+}
+
+func fakeBucketClient(t *testing.T, b *fakeBucket) (*s3.Client, func()) {
+	srv := httptest.NewServer(b)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &s3.Client{
+		Region:         "us-east-1",
+		Domain:         u.Host,
+		Protocol:       "http",
+		UsePathBuckets: true,
+	}, srv.Close
+}
+
+// walkKeys walks a listing, returning the keys it turned up, and
+// whatever it printed to standard error along the way.
+func walkKeys(t *testing.T, c *s3.Client, l listing) ([]string, string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = stderr }()
+
+	var keys []string
+	err = l.eachObject(c, func(o s3.Object) error {
+		keys = append(keys, o.Key)
+		return nil
+	})
+	w.Close()
+	printed, _ := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("walk failed: %s", err)
+	}
+	return keys, string(printed)
+}
+
+func TestListingPages(t *testing.T) {
+	b := &fakeBucket{keys: []string{"a/1", "a/2", "a/3", "b/1"}, pageSize: 2}
+	c, done := fakeBucketClient(t, b)
+	defer done()
+
+	l := listing{Bucket: "bucket", Prefix: "a/"}
+	keys, printed := walkKeys(t, c, l)
+	if got := strings.Join(keys, " "); got != "a/1 a/2 a/3" {
+		t.Errorf("walked %q, wanted %q", got, "a/1 a/2 a/3")
+	}
+	if printed != "" {
+		t.Errorf("unexpected output: %q", printed)
+	}
+
+	if len(b.requests) != 2 {
+		t.Fatalf("made %d requests, wanted 2", len(b.requests))
+	}
+	if tok := b.requests[0].Get("continuation-token"); tok != "" {
+		t.Errorf("first page was asked for with continuation token %q", tok)
+	}
+	if tok := b.requests[1].Get("continuation-token"); tok != "a/2" {
+		t.Errorf("second page was asked for with continuation token %q, wanted %q", tok, "a/2")
+	}
+	if !l.whole(len(keys)) {
+		t.Errorf("a full walk isn't whole")
+	}
+}
+
+func TestListingMaxKeys(t *testing.T) {
+	b := &fakeBucket{keys: []string{"k1", "k2", "k3", "k4", "k5"}, pageSize: 2}
+	c, done := fakeBucketClient(t, b)
+	defer done()
+
+	l := listing{Bucket: "bucket", MaxKeys: 3}
+	keys, printed := walkKeys(t, c, l)
+	if got := strings.Join(keys, " "); got != "k1 k2 k3" {
+		t.Errorf("walked %q, wanted %q", got, "k1 k2 k3")
+	}
+	if len(b.requests) != 2 {
+		t.Fatalf("made %d requests, wanted 2", len(b.requests))
+	}
+	if n := b.requests[1].Get("max-keys"); n != "1" {
+		t.Errorf("second page asked for max-keys=%q, wanted 1", n)
+	}
+	if !strings.Contains(printed, "stopped after 3 keys") || !strings.Contains(printed, "--continuation-token k3") {
+		t.Errorf("didn't print how to continue: %q", printed)
+	}
+	if l.whole(len(keys)) {
+		t.Errorf("a walk cut short by --max-keys is whole")
+	}
+
+	// picking up where that left off gets the rest.
+	b.requests = nil
+	l = listing{Bucket: "bucket", ContinuationToken: "k3"}
+	keys, _ = walkKeys(t, c, l)
+	if got := strings.Join(keys, " "); got != "k4 k5" {
+		t.Errorf("continued walk got %q, wanted %q", got, "k4 k5")
+	}
+	if tok := b.requests[0].Get("continuation-token"); tok != "k3" {
+		t.Errorf("continued walk asked for continuation token %q, wanted k3", tok)
+	}
+	if !l.whole(len(keys)) {
+		t.Errorf("a continued walk isn't whole")
+	}
+}
+
+func TestListingWhole(t *testing.T) {
+	tests := []struct {
+		l     listing
+		seen  int
+		whole bool
+	}{
+		{listing{}, 0, true},
+		{listing{}, 5000, true},
+		{listing{MaxKeys: 10}, 3, true},
+		{listing{MaxKeys: 10}, 10, false},
+		{listing{StartAfter: "k2"}, 3, false},
+		{listing{StartAfter: "k2", MaxKeys: 10}, 3, false},
+		{listing{ContinuationToken: "k3"}, 3, true},
+	}
+	for _, test := range tests {
+		if got := test.l.whole(test.seen); got != test.whole {
+			t.Errorf("%+v.whole(%d) = %v, wanted %v", test.l, test.seen, got, test.whole)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// Everything s3 has to say about what it is doing (as opposed to
+// the output it was asked for) goes through the logger, at one of
+// these levels.  -D turns on debug logging; -T turns on trace
+// logging, which adds dumps of every HTTP request and response.
+//
+// Log messages can be plain text (the default), or JSON objects,
+// one per line, for feeding into other tools.  Either way, secrets
+// (keys, session tokens, signatures) are masked before anything is
+// written out.
+
+type level int
+
+const (
+	levelError level = iota
+	levelWarn
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+var levels = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l level) String() string {
+	return levels[l]
+}
+
+func parseLevel(s string) (level, error) {
+	for i, name := range levels {
+		if strings.EqualFold(s, name) {
+			return level(i), nil
+		}
+	}
+	return levelError, fmt.Errorf("unrecognized --log-level '%s' (must be one of %s)", s, strings.Join(levels, ", "))
+}
+
+// A logSink is where log messages go.  Parallel uploads and
+// downloads log from several goroutines at once, so writes are
+// serialized, to keep lines from interleaving.
+type logSink struct {
+	sync.Mutex
+	level level
+	json  bool
+	out   io.Writer
+	stamp bool
+}
+
+var logger = &logSink{level: levelWarn, out: os.Stderr}
+
+// write writes out a log line.  Log files get a timestamp on each
+// (text) line, since they tend to outlive the terminal session.
+// Lines logged to standard error have to go above the progress bar
+// (see progress.go), if there is one.
+func (s *logSink) write(line string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.stamp && !s.json {
+		line = time.Now().Format("2006-01-02 15:04:05.000 ") + line
+	}
+	if s.out == os.Stderr {
+		aboveProgress(func() { fmt.Fprintf(s.out, "%s", line) })
+		return
+	}
+	fmt.Fprintf(s.out, "%s", line)
+}
+
+// setupLogging configures the logger from the --log-* options,
+// and -D / -T, which imply (at least) debug and trace levels.
+func setupLogging() error {
+	if opts.LogLevel != "" {
+		l, err := parseLevel(opts.LogLevel)
+		if err != nil {
+			return err
+		}
+		logger.level = l
+	}
+	if opts.Debug && logger.level < levelDebug {
+		logger.level = levelDebug
+	}
+	if opts.Trace {
+		logger.level = levelTrace
+	}
+
+	switch opts.LogFormat {
+	case "", "text":
+	case "json":
+		logger.json = true
+	default:
+		return fmt.Errorf("unrecognized --log-format '%s' (must be either text or json)", opts.LogFormat)
+	}
+
+	if opts.LogFile != "" {
+		f, err := os.OpenFile(opts.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to open --log-file: %s", err)
+		}
+		logger.out = f
+		logger.stamp = true
+	}
+	return nil
+}
+
+func logging(l level) bool {
+	return l <= logger.level
+}
+
+func debugf(m string, args ...interface{}) {
+	logf(levelDebug, nil, m, args...)
+}
+
+func warnf(m string, args ...interface{}) {
+	logf(levelWarn, nil, m, args...)
+}
+
+var ansiEscape = regexp.MustCompile("\033\\[\\d+(;\\d+)?m")
+
+// logf logs a message, with optional structured fields, if the
+// logger is at (or above) the given level.  The message can use
+// go-ansi @X{...} markup; it is stripped out for JSON.
+func logf(l level, fields map[string]interface{}, m string, args ...interface{}) {
+	if !logging(l) {
+		return
+	}
+
+	if logger.json {
+		entry := map[string]interface{}{
+			"time":  time.Now().UTC().Format(time.RFC3339Nano),
+			"level": l.String(),
+			"msg":   redact(ansiEscape.ReplaceAllString(fmt.Sprintf(m, args...), "")),
+		}
+		for k, v := range fields {
+			if s, ok := v.(string); ok {
+				v = redact(s)
+			}
+			entry[k] = v
+		}
+		var b strings.Builder
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(entry); err != nil {
+			return
+		}
+		logger.write(b.String())
+		return
+	}
+
+	prefix := map[level]string{
+		levelError: "@R{ERROR> }",
+		levelWarn:  "@M{WARN> }",
+		levelInfo:  "@G{INFO> }",
+		levelDebug: "@Y{DEBUG> }",
+		levelTrace: "@C{TRACE> }",
+	}[l]
+
+	line := fmt.Sprintf(prefix+m, args...)
+	for k, v := range fields {
+		line += fmt.Sprintf(" %s=%v", k, v)
+	}
+	logger.write(redact(line) + "\n")
+}
+
+// traceHTTP logs an HTTP request or response dump, at the trace
+// level.  In text mode, these are set off from the rest of the log
+// by a banner, so that they are easier to pick out.
+func traceHTTP(what string, dump []byte) {
+	if !logging(levelTrace) {
+		return
+	}
+	if logger.json {
+		logf(levelTrace, map[string]interface{}{"http": what, "dump": string(dump)}, "http %s", what)
+		return
+	}
+
+	color := "C"
+	if what == "response" {
+		color = "W"
+	}
+	banner := "---[ " + what + " ]" + strings.Repeat("-", 42-len(what))
+	logger.write(redact(fmt.Sprintf("%s\n@"+color+"{%s}\n\n", banner, dump)))
+}
+
+// secrets holds the actual secret values in play (the secret key,
+// session tokens, and so on), so that they can be masked wherever
+// they turn up, not just where we expect them.
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// secret registers a value that must never be logged.
+func secret(s string) {
+	if len(s) < 8 {
+		return // too short to mask without clobbering innocent text.
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values = append(secrets.values, s)
+}
+
+const redacted = "<redacted>"
+
+// redactions mask the secrets that we know the shape of: signatures,
+// session tokens, and keys in HTTP headers, query strings, and the
+// XML / JSON / form bodies that carry credentials around.  Access
+// Key IDs aren't secret, but are trimmed to their last four digits.
+var redactions = []struct {
+	re   *regexp.Regexp
+	with string
+}{
+	{regexp.MustCompile(`(?i)(Credential=)[A-Z0-9]*([A-Z0-9]{4})(/|%2F)`), "${1}****${2}${3}"},
+	{regexp.MustCompile(`(?i)(Signature=)[0-9a-f]+`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)(Authorization: AWS )[A-Z0-9]*([A-Z0-9]{4}):\S+`), "${1}****${2}:" + redacted},
+	{regexp.MustCompile(`(?im)^((?:X-Amz-Security-Token|X-Amz-Server-Side-Encryption-Customer-Key|X-Aws-Ec2-Metadata-Token|X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key):[ \t]*)\S.*$`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)((?:X-Amz-Security-Token|X-Amz-Signature|TokenCode)=)[^&\s"]+`), "${1}" + redacted},
+	{regexp.MustCompile(`(<(SecretAccessKey|SessionToken)>)[^<]*(</)`), "${1}" + redacted + "${3}"},
+	{regexp.MustCompile(`("(?:SecretAccessKey|SessionToken|Token)"\s*:\s*")[^"]*(")`), "${1}" + redacted + "${2}"},
+}
+
+// redact masks all the secrets in a log message, or HTTP dump.
+func redact(s string) string {
+	for _, r := range redactions {
+		s = r.re.ReplaceAllString(s, r.with)
+	}
+
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+// traceWriter lets go-s3 trace its own requests through the logger
+// (and through redact()), instead of straight to standard error.
+// go-s3 writes each request or response dump in a single call, with
+// the same banners that traceHTTP uses.
+type traceWriter struct{}
+
+func (traceWriter) Write(b []byte) (int, error) {
+	s := string(b)
+	what := "request"
+	if strings.HasPrefix(s, "---[ response ]") {
+		what = "response"
+	}
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = s[i+1:]
+	}
+	traceHTTP(what, []byte(strings.TrimRight(s, "\n")))
+	return len(b), nil
+}
+
+// masked shows just enough of an Access Key ID to tell which one
+// it is (the last four characters).
+func masked(aki string) string {
+	if len(aki) <= 4 {
+		return aki
+	}
+	return "****" + aki[len(aki)-4:]
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	fmt "github.com/jhunt/go-ansi"
 	"github.com/jhunt/go-cli"
@@ -22,17 +23,41 @@ var opts struct {
 	Debug   bool `cli:"-D, --debug"   env:"S3_DEBUG"`
 	Trace   bool `cli:"-T, --trace"   env:"S3_TRACE"`
 
-	ID     string `cli:"--aki"        env:"S3_AKI"`
-	Key    string `cli:"--key"        env:"S3_KEY"`
+	LogLevel  string `cli:"--log-level"  env:"S3_LOG_LEVEL"`
+	LogFormat string `cli:"--log-format" env:"S3_LOG_FORMAT"`
+	LogFile   string `cli:"--log-file"   env:"S3_LOG_FILE"`
+
+	Profile string `cli:"--profile" env:"S3_PROFILE"`
+
+	ID     string `cli:"--aki"           env:"S3_AKI"`
+	Key    string `cli:"--key"           env:"S3_KEY"`
+	Token  string `cli:"--session-token" env:"S3_SESSION_TOKEN"`
 	URL    string `cli:"--s3-url"     env:"S3_URL"`
 	Region string `cli:"-r, --region" env:"S3_REGION"`
 
+	AssumeRole      string `cli:"--assume-role"       env:"S3_ASSUME_ROLE"`
+	ExternalID      string `cli:"--external-id"       env:"S3_EXTERNAL_ID"`
+	RoleSessionName string `cli:"--role-session-name" env:"S3_ROLE_SESSION_NAME"`
+	MFASerial       string `cli:"--mfa-serial"        env:"S3_MFA_SERIAL"`
+	MFAToken        string `cli:"--mfa-token"`
+	STSURL          string `cli:"--sts-url"           env:"S3_STS_URL"`
+
+	Anonymous   bool  `env:"S3_ANONYMOUS"`
+	SignRequest *bool `cli:"--sign-request, --no-sign-request"`
+
 	SkipVerify bool `cli:"-k, --insecure"     env:"S3_INSECURE"`
 	PathBased  bool `cli:"-P, --path-buckets" env:"S3_USE_PATH"`
 
-	Recursive bool `cli:"-R"`
+	Recursive bool   `cli:"-R"`
+	Output    string `cli:"-o, --output" env:"S3_OUTPUT"`
+
+	MaxKeys           int    `cli:"--max-keys"`
+	StartAfter        string `cli:"--start-after"`
+	ContinuationToken string `cli:"--continuation-token"`
 
 	Commands struct{} `cli:"commands"`
+	Config   struct{} `cli:"config"`
+	Whoami   struct{} `cli:"whoami"`
 	ACLs     struct{} `cli:"acls"`
 
 	ShowHelp struct{} `cli:"help"`
@@ -53,22 +78,97 @@ var opts struct {
 		To          string `cli:"--to"`
 		ContentType string `cli:"-t, --content-type"`
 		Parallel    int    `cli:"-n, --parallel"      env:"S3_THREADS"`
+		PartSize    string `cli:"--part-size"         env:"S3_PART_SIZE"`
+		Checksum    string `cli:"--checksum"`
+
+		Meta               []string `cli:"--meta"`
+		CacheControl       string   `cli:"--cache-control"`
+		ContentEncoding    string   `cli:"--content-encoding"`
+		ContentDisposition string   `cli:"--content-disposition"`
+		ContentLanguage    string   `cli:"--content-language"`
+		Expires            string   `cli:"--expires"`
+
+		Include        []string `cli:"--include"`
+		Exclude        []string `cli:"--exclude"`
+		FollowSymlinks bool     `cli:"--follow-symlinks"`
+
+		Resume   bool `cli:"--resume"`
+		Progress bool `cli:"--progress"`
 	} `cli:"put, upload"`
 
 	Download struct {
-		To string `cli:"--to"`
+		To       string `cli:"--to"`
+		Parallel int    `cli:"-n, --parallel" env:"S3_THREADS"`
+		Continue bool   `cli:"-c, --continue"`
+		Range    string `cli:"--range"`
+		PartSize string `cli:"--part-size"`
+		Progress bool   `cli:"--progress"`
 	} `cli:"get, download"`
 
 	Cat struct {
+		Range    string `cli:"--range"`
+		Parallel int    `cli:"-n, --parallel" env:"S3_THREADS"`
+		PartSize string `cli:"--part-size"`
 	} `cli:"cat"`
 
+	Stat struct {
+	} `cli:"stat, head"`
+
+	Exists struct {
+	} `cli:"exists"`
+
+	Verify struct {
+	} `cli:"verify"`
+
+	Copy struct {
+		MetadataDirective string `cli:"--metadata-directive"`
+		ContentType       string `cli:"-t, --content-type"`
+	} `cli:"cp, copy"`
+
+	Move struct {
+		MetadataDirective string `cli:"--metadata-directive"`
+		ContentType       string `cli:"-t, --content-type"`
+	} `cli:"mv, move, rename"`
+
+	Sync struct {
+		Delete   bool     `cli:"--delete"`
+		DryRun   bool     `cli:"--dry-run"`
+		Include  []string `cli:"--include"`
+		Exclude  []string `cli:"--exclude"`
+		Parallel int      `cli:"-n, --parallel" env:"S3_THREADS"`
+		PartSize string   `cli:"--part-size"`
+	} `cli:"sync"`
+
+	Uploads struct {
+		OlderThan string `cli:"--older-than"`
+		DryRun    bool   `cli:"--dry-run"`
+	} `cli:"uploads"`
+
 	GenerateURL struct {
+		Method  string `cli:"-X, --method"`
+		Expires string `cli:"-e, --expires"`
+
+		ContentType        string `cli:"--response-content-type"`
+		ContentDisposition string `cli:"--response-content-disposition"`
+		ContentEncoding    string `cli:"--response-content-encoding"`
+		ContentLanguage    string `cli:"--response-content-language"`
+		CacheControl       string `cli:"--response-cache-control"`
+		ResponseExpires    string `cli:"--response-expires"`
 	} `cli:"url"`
 
+	PostPolicy struct {
+		KeyPrefix   string `cli:"--key-prefix"`
+		MaxSize     string `cli:"--max-size"`
+		ContentType string `cli:"-t, --content-type"`
+		Expires     string `cli:"-e, --expires"`
+		HTML        bool   `cli:"--html"`
+	} `cli:"post-policy"`
+
 	Delete struct {
 	} `cli:"rm, remove, delete"`
 
 	List struct {
+		Delimiter string `cli:"-d, --delimiter"`
 	} `cli:"ls, list"`
 
 	ChangeACL struct {
@@ -91,15 +191,35 @@ func client() (*s3.Client, error) {
 		scheme = u.Scheme
 	}
 
-	if opts.ID == "" {
-		return nil, fmt.Errorf("missing required --id (or $S3_AKI) value")
+	// --aki and --key go together; if neither is given, we go
+	// looking for credentials the same way the AWS CLI does.
+	var (
+		cr    credentials
+		found provider
+	)
+	if opts.Anonymous {
+		debugf("sending @Y{unsigned} requests, without credentials")
+		found = provider{Name: "none (--no-sign-request)"}
+	} else if opts.ID != "" || opts.Key != "" {
+		if opts.ID == "" {
+			return nil, fmt.Errorf("missing required --aki (or $S3_AKI) value")
+		}
+		if opts.Key == "" {
+			return nil, fmt.Errorf("missing required --key (or $S3_KEY) value")
+		}
+		cr = credentials{AccessKeyID: opts.ID, SecretAccessKey: opts.Key, Token: opts.Token}
+		found = provider{Name: "--aki / --key"}
+	} else {
+		var err error
+		cr, found, err = findCredentials()
+		if err != nil {
+			return nil, err
+		}
 	}
-	debugf("setting AKI to @G{%s}", opts.ID)
-
-	if opts.Key == "" {
-		return nil, fmt.Errorf("missing required --key (or $S3_KEY) value")
+	if !opts.Anonymous {
+		debugf("setting AKI to @G{%s}", masked(cr.AccessKeyID))
 	}
-	debugf("setting Key to @G{%s}", opts.Key)
+	setCredentials(cr)
 	if opts.Bucket != "" {
 		debugf("using bucket @G{%s} in region @G{%s}", opts.Bucket, opts.Region)
 	} else if opts.Region != "" {
@@ -108,13 +228,10 @@ func client() (*s3.Client, error) {
 		debugf("no @B{bucket} or @B{region} set")
 	}
 
-	if opts.Trace {
-		os.Setenv("S3_TRACE", "yes")
-	}
-
-	return s3.NewClient(&s3.Client{
-		AccessKeyID:        opts.ID,
-		SecretAccessKey:    opts.Key,
+	c, err := s3.NewClient(&s3.Client{
+		AccessKeyID:        cr.AccessKeyID,
+		SecretAccessKey:    cr.SecretAccessKey,
+		Token:              cr.Token,
 		Domain:             domain,
 		Protocol:           scheme,
 		Region:             opts.Region,
@@ -122,21 +239,58 @@ func client() (*s3.Client, error) {
 		UsePathBuckets:     opts.PathBased,
 		InsecureSkipVerify: opts.SkipVerify,
 	})
+	if err != nil {
+		return nil, err
+	}
+	// go-s3 does its own tracing (if $S3_TRACE is set); that needs
+	// to go through the logger, so that secrets get masked.
+	c.Trace(traceWriter{}, logging(levelTrace), logging(levelTrace))
+
+	if opts.AssumeRole != "" {
+		r := roleRequest{
+			RoleARN:     opts.AssumeRole,
+			SessionName: opts.RoleSessionName,
+			ExternalID:  opts.ExternalID,
+			MFASerial:   opts.MFASerial,
+			MFAToken:    opts.MFAToken,
+		}
+		role, err := assumeRole(c, cr, r)
+		if err != nil {
+			return nil, err
+		}
+		setCredentials(role)
+		debugf("setting AKI to @G{%s} (for role %s)", masked(role.AccessKeyID), r.RoleARN)
+
+		// an MFA code can only be used once, so there's no way to
+		// re-assume the role when these credentials run out.
+		if r.MFASerial != "" {
+			found = provider{Name: "assumed role " + r.RoleARN}
+			role.Expires = time.Time{}
+		} else {
+			found = roleProvider(c, found, cr, r)
+		}
+		cr = role
+	}
+
+	credentialSource = found.Name
+	keepFresh(found, cr)
+	return c, nil
 }
 
+// credentialSource records where client() got its credentials from,
+// for `s3 whoami`.
+var credentialSource string
+
 func bail(err error) {
+	if err == errInterrupted {
+		os.Exit(130)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
 		os.Exit(2)
 	}
 }
 
-func debugf(m string, args ...interface{}) {
-	if opts.Debug {
-		fmt.Fprintf(os.Stderr, "@Y{DEBUG> }"+m+"\n", args...)
-	}
-}
-
 func version() string {
 	if Version == "" {
 		return "(development version)"
@@ -145,17 +299,88 @@ func version() string {
 	}
 }
 
+// defaults fills in whatever options weren't set on the command
+// line, in the environment, or by the profile.
+func defaults() {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.CreateBucket.ACL == "" {
+		opts.CreateBucket.ACL = "private"
+	}
+	for _, n := range []*int{&opts.Upload.Parallel, &opts.Sync.Parallel, &opts.Download.Parallel, &opts.Cat.Parallel} {
+		if *n <= 0 {
+			*n = 2
+		}
+	}
+	if opts.Copy.MetadataDirective == "" {
+		opts.Copy.MetadataDirective = "COPY"
+	}
+	if opts.Move.MetadataDirective == "" {
+		opts.Move.MetadataDirective = "COPY"
+	}
+	if opts.GenerateURL.Method == "" {
+		opts.GenerateURL.Method = "GET"
+	}
+	if opts.GenerateURL.Expires == "" {
+		opts.GenerateURL.Expires = "15m"
+	}
+	if opts.PostPolicy.Expires == "" {
+		opts.PostPolicy.Expires = "1h"
+	}
+	if opts.RoleSessionName == "" {
+		opts.RoleSessionName = defaultSessionName()
+	}
+}
+
 func main() {
 	env.Override(&opts)
-	opts.Region = "us-east-1"
-	opts.CreateBucket.ACL = "private"
-	opts.Upload.Parallel = 2
-
-	command, args, err := cli.Parse(&opts)
+	args, progress, err := progressArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
+		os.Exit(1)
+	}
+	command, args, err := cli.ParseArgs(&opts, args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
 		os.Exit(1)
 	}
+	if opts.Upload.Progress || opts.Download.Progress {
+		progressFormat = progress
+		if progressFormat == "" {
+			progressFormat = "text"
+		}
+	}
+
+	if err := setupLogging(); err != nil {
+		fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
+		os.Exit(1)
+	}
+
+	// go-cli reads --no-sign-request as "turn --sign-request off",
+	// and either flag trumps $S3_ANONYMOUS.
+	if opts.SignRequest != nil {
+		opts.Anonymous = !*opts.SignRequest
+	}
+
+	// flags and environment variables take precedence over
+	// the profile, which takes precedence over the defaults.
+	if command != "config" {
+		if err := loadProfile(); err != nil {
+			fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
+			os.Exit(1)
+		}
+	}
+	defaults()
+
+	if opts.Output == "" {
+		opts.Output = "table"
+	}
+	if !validFormat(opts.Output) {
+		fmt.Fprintf(os.Stderr, "@R{!!! unrecognized --output format '}@Y{%s}@R{'}\n", opts.Output)
+		fmt.Fprintf(os.Stderr, "valid formats are: %s\n", strings.Join(formats, ", "))
+		os.Exit(1)
+	}
 
 	if opts.Version {
 		fmt.Printf("s3 %s\n", version())
@@ -170,12 +395,44 @@ func main() {
 		fmt.Printf("  --debug, -D     Enable verbose logging of what s3 is doing.\n")
 		fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n")
 		fmt.Printf("\n")
+		fmt.Printf("  --log-level LEVEL\n")
+		fmt.Printf("                  How much to log: one of error, warn (the default),\n")
+		fmt.Printf("                  info, debug (same as -D), or trace (same as -T).\n")
+		fmt.Printf("                  Can be set via $S3_LOG_LEVEL.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --log-format FORMAT\n")
+		fmt.Printf("                  Log as plain text (the default), or as json, one\n")
+		fmt.Printf("                  object per line.  Can be set via $S3_LOG_FORMAT.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --log-file FILE Append log messages to FILE, instead of printing\n")
+		fmt.Printf("                  them to standard error.  Can be set via $S3_LOG_FILE.\n")
+		fmt.Printf("                  Secrets are masked in all log output.\n")
+		fmt.Printf("\n")
 		fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
 		fmt.Printf("                  the $S3_AKI environment variable.\n")
 		fmt.Printf("\n")
 		fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
 		fmt.Printf("                  via the $S3_KEY environment variable.\n")
 		fmt.Printf("\n")
+		fmt.Printf("  --session-token TOKEN\n")
+		fmt.Printf("                  The session token that goes with temporary\n")
+		fmt.Printf("                  credentials.  Can be set via $S3_SESSION_TOKEN.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --assume-role ARN\n")
+		fmt.Printf("                  Assume an IAM role, via AWS STS, and act as it.\n")
+		fmt.Printf("                  The role's credentials are cached (in ~/.cache/s3)\n")
+		fmt.Printf("                  until they expire.  Can be set via $S3_ASSUME_ROLE.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --external-id ID          The external ID the role requires, if any.\n")
+		fmt.Printf("  --role-session-name NAME  What to call the role session.\n")
+		fmt.Printf("                            Defaults to s3-$USER.\n")
+		fmt.Printf("  --mfa-serial ARN          The MFA device the role requires, if any.\n")
+		fmt.Printf("  --mfa-token CODE          The current code from that MFA device.\n")
+		fmt.Printf("  --sts-url URL             The URL of the STS API.  Defaults to the\n")
+		fmt.Printf("                            regional AWS endpoint.\n")
+		fmt.Printf("                  (These can be set via $S3_EXTERNAL_ID, $S3_ROLE_SESSION_NAME,\n")
+		fmt.Printf("                   $S3_MFA_SERIAL, and $S3_STS_URL.)\n")
+		fmt.Printf("\n")
 		fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
 		fmt.Printf("                  should be suitable for actual AWS S3.\n")
 		fmt.Printf("                  Can be set via $S3_URL.\n")
@@ -183,11 +440,26 @@ func main() {
 		fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
 		fmt.Printf("                  Can be set via $S3_REGION.\n")
 		fmt.Printf("\n")
+		fmt.Printf("  --no-sign-request\n")
+		fmt.Printf("                  Send unsigned (anonymous) requests, to read from\n")
+		fmt.Printf("                  public buckets without any credentials.  Only works\n")
+		fmt.Printf("                  for ls, get, cat, stat, exists, and verify.\n")
+		fmt.Printf("                  Can be set via $S3_ANONYMOUS=yes.\n")
+		fmt.Printf("\n")
 		fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
 		fmt.Printf("  -P              By default, s3 uses DNS (name) based bucket\n")
 		fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
 		fmt.Printf("                  Can be set via $S3_USE_PATH=yes.\n")
 		fmt.Printf("\n")
+		fmt.Printf("  --profile NAME  Which profile in the configuration file (see\n")
+		fmt.Printf("                  `@W{s3 config}') to take settings from.  Flags and\n")
+		fmt.Printf("                  environment variables override profile settings.\n")
+		fmt.Printf("                  Can be set via $S3_PROFILE.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --output, -o    How to format listings (ls, list-buckets, lsacl):\n")
+		fmt.Printf("                  one of table (the default), json, jsonl, yaml,\n")
+		fmt.Printf("                  csv, or tsv.  Can be set via $S3_OUTPUT.\n")
+		fmt.Printf("\n")
 		fmt.Printf("For a list of all available s3 commands, run `@W{s3 commands}'\n")
 		os.Exit(0)
 	}
@@ -196,10 +468,26 @@ func main() {
 	debugf("determined command to be '@C{%s}'", command)
 	debugf("determined arguments to be @C{%v}", args)
 
+	// unsigned requests are only good for reading public buckets.
+	// (help, commands, config and acls never talk to S3 at all.)
+	if opts.Anonymous && !opts.Help {
+		switch command {
+		case "", "help", "commands", "config", "acls":
+		case "ls", "get", "cat", "stat", "exists", "verify":
+		default:
+			bail(fmt.Errorf("--no-sign-request only works with the ls, get, cat, stat, exists, and verify commands"))
+		}
+		if opts.AssumeRole != "" {
+			bail(fmt.Errorf("--no-sign-request and --assume-role cannot be used together"))
+		}
+	}
+
 	if command == "commands" {
 		fmt.Printf("General usage: @G{s3} @C{COMMAND} @W{[OPTIONS...]}\n\n")
 		fmt.Printf("  @C{acls}            List known ACLs and their purposes / access rules.\n")
 		fmt.Printf("  @C{commands}        List known sub-commands of this s3 client.\n")
+		fmt.Printf("  @C{config}          Manage profiles in the configuration file.\n")
+		fmt.Printf("  @C{whoami}          Show the identity (account, user / role) in use.\n")
 		fmt.Printf("\n")
 		fmt.Printf("  @C{list-buckets}    List all S3 buckets owned by you.\n")
 		fmt.Printf("  @C{create-bucket}   Create a new bucket.\n")
@@ -208,9 +496,17 @@ func main() {
 		fmt.Printf("  @C{put}             Upload a new file to S3.\n")
 		fmt.Printf("  @C{get}             Download a file from S3.\n")
 		fmt.Printf("  @C{cat}             Print the contents of a file in S3.\n")
-		fmt.Printf("  @C{url}             Print the HTTPS URL for a file in S3.\n")
+		fmt.Printf("  @C{stat}            Show the metadata of a file in S3.\n")
+		fmt.Printf("  @C{exists}          Check if a file exists in S3 (via exit code).\n")
+		fmt.Printf("  @C{verify}          Check that a local file matches a file in S3.\n")
+		fmt.Printf("  @C{cp}              Copy a file (or prefix) within / between buckets.\n")
+		fmt.Printf("  @C{mv}              Move (rename) a file or prefix.\n")
+		fmt.Printf("  @C{url}             Print a presigned URL for a file in S3.\n")
+		fmt.Printf("  @C{post-policy}     Sign a policy for uploading from a browser.\n")
 		fmt.Printf("  @C{rm}              Delete file from a bucket.\n")
 		fmt.Printf("  @C{ls}              List the files in a bucket.\n")
+		fmt.Printf("  @C{sync}            Synchronize a local directory with a bucket.\n")
+		fmt.Printf("  @C{uploads}         Manage unfinished multipart uploads.\n")
 		fmt.Printf("\n")
 		fmt.Printf("  @C{chacl}           Change the ACL on a bucket or a file.\n")
 		fmt.Printf("  @C{lsacl}           List the ACL on a bucket or a file.\n")
@@ -219,6 +515,106 @@ func main() {
 		os.Exit(0)
 	}
 
+	if command == "config" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{config} @Y{list}\n")
+			fmt.Printf("       @C{s3} @G{config} @Y{show} [@Y{PROFILE}]\n")
+			fmt.Printf("       @C{s3} @G{config} [--profile @Y{PROFILE}] @Y{set} @Y{SETTING} @Y{VALUE}\n")
+			fmt.Printf("@M{Manage profiles in the configuration file}\n\n")
+			fmt.Printf("The configuration file lives at @C{%s}\n", configFile())
+			fmt.Printf("(see @W{$S3_CONFIG} and @W{$XDG_CONFIG_HOME}).  Each @Y{[profile]} section\n")
+			fmt.Printf("holds the settings to use when that profile is picked via @W{--profile}\n")
+			fmt.Printf("(or @W{$S3_PROFILE}); the @Y{[default]} profile is used otherwise.\n\n")
+			fmt.Printf("Command-line flags always win, followed by environment variables,\n")
+			fmt.Printf("and then the profile.\n\n")
+			fmt.Printf("SETTINGS\n\n")
+			fmt.Printf("  @C{aki}               The Amazon Key ID to use (like @W{--aki}).\n")
+			fmt.Printf("  @C{key}               The Amazon Secret Key to use (like @W{--key}).\n")
+			fmt.Printf("  @C{session-token}     The session token, for temporary credentials.\n")
+			fmt.Printf("  @C{assume-role}       The IAM role to assume (like @W{--assume-role}).\n")
+			fmt.Printf("  @C{external-id}       The external ID the role requires.\n")
+			fmt.Printf("  @C{role-session-name} The session name to assume the role as.\n")
+			fmt.Printf("  @C{mfa-serial}        The MFA device the role requires.\n")
+			fmt.Printf("  @C{sts-url}           The URL of the STS API (like @W{--sts-url}).\n")
+			fmt.Printf("  @C{url}               The full URL to your S3 system (like @W{--s3-url}).\n")
+			fmt.Printf("  @C{region}            The S3 region to operate in (like @W{--region}).\n")
+			fmt.Printf("  @C{path-buckets}      Use path-based bucket addressing (@W{yes} / @W{no}).\n")
+			fmt.Printf("  @C{insecure}          Skip TLS certificate verification (@W{yes} / @W{no}).\n")
+			fmt.Printf("  @C{bucket}            The bucket to use by default (like @W{--bucket}).\n\n")
+			os.Exit(0)
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing sub-command (list, show, or set).}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{config} @Y{list}|@Y{show}|@Y{set} ...\n")
+			os.Exit(1)
+		}
+
+		file := configFile()
+		profiles, err := readConfig(file)
+		bail(err)
+
+		name := opts.Profile
+		if name == "" {
+			name = "default"
+		}
+
+		switch args[0] {
+		case "list", "ls":
+			if len(args) > 1 {
+				bail(fmt.Errorf("too many arguments."))
+			}
+			r := render(
+				column{Field: "profile", Header: "profile", Color: "G"},
+				column{Field: "url", Header: "url"},
+				column{Field: "region", Header: "region"},
+				column{Field: "bucket", Header: "bucket", Color: "Y"},
+			)
+			for _, n := range sortedProfiles(profiles) {
+				p := profiles[n]
+				r.Row(n, orNil(p["url"]), orNil(p["region"]), orNil(p["bucket"]))
+			}
+			bail(r.Close())
+
+		case "show":
+			if len(args) > 2 {
+				bail(fmt.Errorf("too many arguments."))
+			}
+			if len(args) == 2 {
+				name = args[1]
+			}
+			p, ok := profiles[name]
+			if !ok {
+				bail(fmt.Errorf("profile '%s' not found in %s", name, file))
+			}
+			fmt.Printf("@Y{[%s]}\n", name)
+			for _, setting := range settings {
+				if v, ok := p[setting.Key]; ok {
+					if setting.Key == "key" || setting.Key == "session-token" {
+						v = strings.Repeat("*", 8)
+					}
+					fmt.Printf("@C{%-17s} = %s\n", setting.Key, v)
+				}
+			}
+
+		case "set":
+			if len(args) != 3 {
+				fmt.Fprintf(os.Stderr, "@R{!!! wrong number of arguments.}\n")
+				fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{config} [--profile @Y{PROFILE}] @Y{set} @Y{SETTING} @Y{VALUE}\n")
+				os.Exit(1)
+			}
+			key := strings.ToLower(args[1])
+			if !knownSetting(key) {
+				bail(fmt.Errorf("unrecognized setting '%s'", args[1]))
+			}
+			bail(writeSetting(file, name, key, args[2]))
+			fmt.Printf("set @C{%s} in profile @G{%s}\n", key, name)
+
+		default:
+			bail(fmt.Errorf("unrecognized sub-command '%s' (must be list, show, or set)", args[0]))
+		}
+		os.Exit(0)
+	}
+
 	if command == "acls" {
 		fmt.Printf("This utility knows about the following Amazon ACLs:\n\n")
 		fmt.Printf("  @C{private}\n")
@@ -292,6 +688,10 @@ func main() {
 			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
 			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
 
+			fmt.Printf("  --output FORMAT How to format the listing: one of @W{table} (the\n")
+			fmt.Printf("  -o FORMAT       default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
+
 			os.Exit(0)
 		}
 		if len(args) > 0 {
@@ -305,32 +705,23 @@ func main() {
 
 		c.Region = "us-east-1"
 		debugf("listing buckets in region @G{%s}", c.Region)
-		bb, err := c.ListBuckets()
+		bb, err := signer(c).ListBuckets()
 		bail(err)
 
-		if len(bb) == 0 {
+		if len(bb) == 0 && opts.Output == "table" {
 			fmt.Fprintf(os.Stderr, "@R{no buckets found.}\n")
 			os.Exit(0)
 		}
 
-		w := struct {
-			Name         int
-			CreationDate int
-			OwnerName    int
-		}{
-			Name:         len("bucket"),
-			CreationDate: len("created at"),
-			OwnerName:    len("owner"),
-		}
-		for _, b := range bb {
-			w.Name = max(w.Name, len(b.Name))
-			w.CreationDate = max(w.CreationDate, len(fmt.Sprintf("%s", b.CreationDate)))
-			w.OwnerName = max(w.OwnerName, len(b.OwnerName))
-		}
-		fmt.Printf("%-*s  %-*s  %-*s\n", w.Name, "bucket", w.CreationDate, "created at", w.OwnerName, "owner")
+		r := render(
+			column{Field: "name", Header: "bucket", Color: "G"},
+			column{Field: "created_at", Header: "created at"},
+			column{Field: "owner", Header: "owner", Color: "M"},
+		)
 		for _, b := range bb {
-			fmt.Printf("@G{%-*s}  %-*s  @M{%-*s}\n", w.Name, b.Name, w.CreationDate, b.CreationDate, w.OwnerName, b.OwnerName)
+			r.Row(b.Name, b.CreationDate, b.OwnerName)
 		}
+		bail(r.Close())
 		os.Exit(0)
 	}
 
@@ -385,7 +776,7 @@ func main() {
 		c.Region = "us-east-1"
 		debugf("creating bucket @G{%s} in region @G{%s}", args[0], c.Region)
 		debugf("using bucket access control policy @G{%s}", opts.CreateBucket.ACL)
-		err = c.CreateBucket(args[0], "", opts.CreateBucket.ACL)
+		err = signer(c).CreateBucket(args[0], "", opts.CreateBucket.ACL)
 		bail(err)
 
 		fmt.Printf("bucket @Y{%s} created with acl @C{%s}.\n", args[0], opts.CreateBucket.ACL)
@@ -423,6 +814,18 @@ func main() {
 			fmt.Printf("  -R              Recursively remove all of the files in the bucket\n")
 			fmt.Printf("                  before deleting it.  @R{This is dangerous}.\n\n")
 
+			fmt.Printf("  --max-keys N    Stop after listing @W{N} keys, and print the\n")
+			fmt.Printf("                  @W{--continuation-token} needed to pick up from there.\n")
+			fmt.Printf("                  With this (or @W{--start-after}), the bucket itself\n")
+			fmt.Printf("                  is left alone, since it may not be empty yet.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  Only consider keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a listing that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
 			os.Exit(0)
 		}
 		if len(args) == 0 {
@@ -443,17 +846,20 @@ func main() {
 		if opts.Recursive {
 			debugf("recursively deleting all files in bucket...")
 			c.Bucket = args[0]
-			files, err := c.List()
-			bail(err)
-
-			for _, f := range files {
+			l, n := newListing(c.Bucket, ""), 0
+			bail(l.eachObject(c, func(f s3.Object) error {
 				debugf("  - deleting @R{%s}", f.Key)
-				bail(c.Delete(f.Key))
+				n++
+				return signer(c).Delete(f.Key)
+			}))
+			if !l.whole(n) {
+				fmt.Printf("deleted @G{%d} file(s); bucket @Y{%s} may still have files in it, so it was not deleted.\n", n, args[0])
+				os.Exit(0)
 			}
 		}
 
 		debugf("deleting bucket @R{%s} from region @R{%s}", c.Bucket, c.Region)
-		bail(c.DeleteBucket(args[0]))
+		bail(signer(c).DeleteBucket(args[0]))
 
 		fmt.Printf("bucket @Y{%s} deleted.\n", args[0])
 		os.Exit(0)
@@ -462,7 +868,8 @@ func main() {
 	if command == "put" {
 		if opts.Help {
 			fmt.Printf("USAGE: @C{s3} @G{put} [OPTIONS] @Y{local/file/path}\n")
-			fmt.Printf("@M{Uploads a local file to an S3 bucket}\n\n")
+			fmt.Printf("       @C{s3} @G{put} -R [OPTIONS] @Y{local/dir/}\n")
+			fmt.Printf("@M{Uploads a local file (or directory) to an S3 bucket}\n\n")
 			fmt.Printf("OPTIONS\n\n")
 			fmt.Printf("  --help, -h      Show this help screen.\n")
 			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
@@ -497,6 +904,24 @@ func main() {
 			fmt.Printf("                  Defaults to 2.\n")
 			fmt.Printf("                  Can be set via @W{$S3_THREADS=N}.\n\n")
 
+			fmt.Printf("  --part-size SIZE\n")
+			fmt.Printf("                  How big each part of a (multipart) upload is,\n")
+			fmt.Printf("                  between 5M (the default) and 5G.  S3 allows at\n")
+			fmt.Printf("                  most 10,000 parts, so files that would need more\n")
+			fmt.Printf("                  get bigger parts, automatically.  When uploading\n")
+			fmt.Printf("                  from standard input, parts double in size every\n")
+			fmt.Printf("                  1,000 parts (and the last 1,000 are 5G), since\n")
+			fmt.Printf("                  there's no telling how big the upload will get.\n")
+			fmt.Printf("                  Every thread holds a part in memory.\n")
+			fmt.Printf("                  Can be set via @W{$S3_PART_SIZE}.\n\n")
+
+			fmt.Printf("  --checksum ALGORITHM\n")
+			fmt.Printf("                  Send a checksum of each part, for S3 to check it\n")
+			fmt.Printf("                  against, in addition to its MD5.  One of @W{sha256},\n")
+			fmt.Printf("                  @W{sha1}, @W{crc32c}, or @W{crc32}.  The checksum of the\n")
+			fmt.Printf("                  whole file is stored in its metadata, so that\n")
+			fmt.Printf("                  @W{s3 get} can check downloads against it.\n\n")
+
 			fmt.Printf("  --to rel/path   The relative path (inside the bucket) to upload\n")
 			fmt.Printf("                  the file to.  Defaults to the given path with\n")
 			fmt.Printf("                  all leading . and / characters removed.\n\n")
@@ -507,6 +932,57 @@ func main() {
 			fmt.Printf("                  By default, this will be automatically detected\n")
 			fmt.Printf("                  from the first 512 bytes of the input.\n\n")
 
+			fmt.Printf("  --meta KEY=VALUE\n")
+			fmt.Printf("                  Set a piece of user metadata (an @W{x-amz-meta-KEY}\n")
+			fmt.Printf("                  header) on the uploaded file.  Can be given more\n")
+			fmt.Printf("                  than once.\n\n")
+
+			fmt.Printf("  --cache-control VALUE\n")
+			fmt.Printf("  --content-encoding VALUE\n")
+			fmt.Printf("  --content-disposition VALUE\n")
+			fmt.Printf("  --content-language VALUE\n")
+			fmt.Printf("                  Set the corresponding HTTP header on the uploaded\n")
+			fmt.Printf("                  file, to be sent back whenever it is downloaded.\n\n")
+
+			fmt.Printf("  --expires DATE  Set the Expires HTTP header on the uploaded file.\n")
+			fmt.Printf("                  @W{DATE} can be an HTTP date, or an RFC3339 timestamp\n")
+			fmt.Printf("                  (i.e. 2006-01-02T15:04:05Z).\n\n")
+
+			fmt.Printf("  --resume        Pick up an interrupted upload where it left off,\n")
+			fmt.Printf("                  rather than starting over.  @G{s3} keeps a journal\n")
+			fmt.Printf("                  of each upload's progress (in @C{~/.local/state/s3}),\n")
+			fmt.Printf("                  and asks S3 which parts it already has.  The file\n")
+			fmt.Printf("                  must not have changed in the meantime, and its\n")
+			fmt.Printf("                  metadata options are the ones it started with.\n")
+			fmt.Printf("                  Without @W{--resume}, interrupted uploads of the\n")
+			fmt.Printf("                  same file are aborted.  Uploads from standard\n")
+			fmt.Printf("                  input cannot be resumed.\n\n")
+
+			fmt.Printf("  --progress      Show a progress bar (on standard error) with how\n")
+			fmt.Printf("                  much has been uploaded, how fast, how long the rest\n")
+			fmt.Printf("                  should take, and how many parts are in flight.\n")
+			fmt.Printf("                  With @W{--progress=json}, write progress events to\n")
+			fmt.Printf("                  standard error instead, one JSON object per line.\n\n")
+
+			fmt.Printf("  -R              Recursively upload every regular file under the\n")
+			fmt.Printf("                  given directory, keeping its path relative to that\n")
+			fmt.Printf("                  directory.  With @W{--to}, files are uploaded under\n")
+			fmt.Printf("                  that prefix instead of the directory name.\n\n")
+
+			fmt.Printf("  --include GLOB  With @W{-R}, only upload files whose relative path\n")
+			fmt.Printf("                  (or base name) matches @W{GLOB}.  A @W{*} only matches\n")
+			fmt.Printf("                  within one directory, but @W{dir/} or @W{dir/*} matches\n")
+			fmt.Printf("                  everything under @W{dir}.  Can be given more than once.\n\n")
+
+			fmt.Printf("  --exclude GLOB  With @W{-R}, skip files whose relative path (or base\n")
+			fmt.Printf("                  name) matches @W{GLOB}.  Can be given more than once,\n")
+			fmt.Printf("                  and takes precedence over @W{--include}.\n\n")
+
+			fmt.Printf("  --follow-symlinks\n")
+			fmt.Printf("                  With @W{-R}, upload the files that symbolic links\n")
+			fmt.Printf("                  point to (and descend into linked directories).\n")
+			fmt.Printf("                  By default, symbolic links are skipped.\n\n")
+
 			fmt.Printf("  You can give the file name to upload as @Y{-}, in which case\n")
 			fmt.Printf("  the data to upload will be read from standard input, and the\n")
 			fmt.Printf("  destination option (@W{--to}) must be specified.\n\n")
@@ -526,18 +1002,75 @@ func main() {
 			if arg == "-" && opts.Upload.To == "" {
 				bail(fmt.Errorf("uploading from stdin requires the --to option."))
 			}
+			if arg == "-" && opts.Upload.Resume {
+				bail(fmt.Errorf("uploads from standard input cannot be resumed (there's no way to re-read the data that was already sent)."))
+			}
 		}
 
 		if opts.Upload.To != "" && len(args) > 1 {
 			bail(fmt.Errorf("the --to option cannot be specified with multiple uploads."))
 		}
 
+		headers, err := uploadHeaders()
+		bail(err)
+		partSize, err := partSizeOption(opts.Upload.PartSize)
+		bail(err)
+		if partSize != 0 && (partSize < uploadPartSize || partSize > maxPartSize) {
+			bail(fmt.Errorf("invalid --part-size '%s' (must be between 5M and 5G)", opts.Upload.PartSize))
+		}
+		uo := uploadOptions{Threads: opts.Upload.Parallel, PartSize: partSize}
+		if opts.Upload.Checksum != "" {
+			uo.Checksum, err = checksumAlgorithmNamed(opts.Upload.Checksum)
+			bail(err)
+		}
+
 		c, err := client()
 		bail(err)
 
 		debugf("spinning up @W{%d} i/o thread(s) for uploading data.", opts.Upload.Parallel)
 
-		preamble := make([]byte, 512)
+		if opts.Recursive {
+			var files, total int64
+			for _, dir := range args {
+				if dir == "-" {
+					bail(fmt.Errorf("standard input cannot be uploaded recursively."))
+				}
+				if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+					bail(fmt.Errorf("%s: not a directory", dir))
+				}
+
+				prefix := opts.Upload.To
+				if prefix == "" {
+					prefix = strings.TrimLeft(dir, "./")
+				}
+				if prefix != "" && !strings.HasSuffix(prefix, "/") {
+					prefix += "/"
+				}
+
+				local, err := localFiles(dir, filter{
+					Include: opts.Upload.Include,
+					Exclude: opts.Upload.Exclude,
+				}, opts.Upload.FollowSymlinks)
+				bail(err)
+
+				for _, f := range local {
+					debugf("uploading @C{%s} to @Y{%s}:@C{%s}", f.Path, c.Bucket, prefix+f.Rel)
+					from, err := os.Open(f.Path)
+					bail(err)
+
+					n, err := upload(c, from, prefix+f.Rel, headers, uo)
+					from.Close()
+					bail(err)
+
+					files++
+					total += n
+				}
+			}
+
+			fmt.Printf("uploaded @G{%d} file(s), @G{%s} (%d bytes) in total.\n", files, s3.Bytes(total), total)
+			os.Exit(0)
+		}
+
 		for _, file := range args {
 			to := opts.Upload.To
 			if to == "" {
@@ -554,41 +1087,7 @@ func main() {
 				defer from.Close()
 			}
 
-			n := 0
-			ctype := ""
-			if opts.Upload.ContentType != "" {
-				ctype = opts.Upload.ContentType
-			} else {
-				debugf("@W{%s}: detecting content-type from first 512b", file)
-				n, err = from.Read(preamble)
-				bail(err)
-
-				ctype = http.DetectContentType(preamble)
-			}
-
-			rd, wr := io.Pipe()
-			go func() {
-				for n > 0 {
-					writ, err := wr.Write(preamble)
-					bail(err)
-					preamble = preamble[writ:]
-					n -= writ
-				}
-				io.Copy(wr, from)
-				wr.Close()
-			}()
-
-			debugf("@W{%s}: uploading @M{%s} file to @C{%s}", file, ctype, to)
-			u, err := c.NewUpload(to, &http.Header{
-				"Content-Type": []string{ctype},
-			})
-			bail(err)
-
-			// 1<<20 == 2^20
-			_, err = u.ParallelStream(rd, 5*(1<<20), opts.Upload.Parallel)
-			bail(err)
-
-			err = u.Done()
+			_, err = upload(c, from, to, headers, uo)
 			bail(err)
 		}
 
@@ -598,7 +1097,8 @@ func main() {
 	if command == "get" {
 		if opts.Help {
 			fmt.Printf("USAGE: @C{s3} @G{get} [OPTIONS] @Y{remote/file/path}\n")
-			fmt.Printf("@M{Download a file from S3}\n\n")
+			fmt.Printf("       @C{s3} @G{get} -R [OPTIONS] @Y{remote/prefix/}\n")
+			fmt.Printf("@M{Download a file (or everything under a prefix) from S3}\n\n")
 			fmt.Printf("OPTIONS\n\n")
 			fmt.Printf("  --help, -h      Show this help screen.\n")
 			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
@@ -618,6 +1118,11 @@ func main() {
 			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
 			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
 
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
 			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
 			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
 			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
@@ -630,6 +1135,42 @@ func main() {
 			fmt.Printf("                  Defaults to the final component of the key in the\n")
 			fmt.Printf("                  bucket (i.e. a/b/c/d -> d)\n\n")
 
+			fmt.Printf("  -R              Recursively download every file under the given\n")
+			fmt.Printf("                  prefix, into the @W{--to} directory, recreating the\n")
+			fmt.Printf("                  key hierarchy as local directories.  Keys that would\n")
+			fmt.Printf("                  end up outside of that directory are refused.\n\n")
+
+			fmt.Printf("  --parallel N    How many parts of the file to download at once,\n")
+			fmt.Printf("  -n N            or, with @W{-R}, how many files to download at once.\n")
+			fmt.Printf("                  Defaults to 2.\n")
+			fmt.Printf("                  Can be set via @W{$S3_THREADS=N}.\n\n")
+
+			fmt.Printf("  --part-size SIZE\n")
+			fmt.Printf("                  How big each of those parts is, i.e. @W{16M}.\n")
+			fmt.Printf("                  Files that fit in a single part (or that come\n")
+			fmt.Printf("                  from servers that can't do ranged downloads) are\n")
+			fmt.Printf("                  downloaded in one go.  Defaults to 8M.\n\n")
+
+			fmt.Printf("  --continue, -c  Pick up an interrupted download where it left off,\n")
+			fmt.Printf("                  by fetching only the bytes that aren't already in\n")
+			fmt.Printf("                  the local file.  If the remote file has changed\n")
+			fmt.Printf("                  since, @G{s3} refuses to continue, rather than\n")
+			fmt.Printf("                  splicing two different versions together.\n")
+			fmt.Printf("                  With @W{-R}, files that are already complete are\n")
+			fmt.Printf("                  skipped.\n\n")
+
+			fmt.Printf("  --range RANGE   Only download part of the file: bytes @W{FIRST-LAST}\n")
+			fmt.Printf("                  (counting from 0, inclusive), @W{FIRST-} through\n")
+			fmt.Printf("                  the end, or @W{-N}, the last N bytes.\n\n")
+
+			fmt.Printf("  --progress      Show a progress bar (on standard error) with how\n")
+			fmt.Printf("                  much has been downloaded, how fast, how long the\n")
+			fmt.Printf("                  rest should take, and how many parts are in flight.\n")
+			fmt.Printf("                  With @W{-R}, all of the files count towards a single\n")
+			fmt.Printf("                  progress bar.  With @W{--progress=json}, write progress\n")
+			fmt.Printf("                  events to standard error instead, one JSON object\n")
+			fmt.Printf("                  per line.\n\n")
+
 			fmt.Printf("  You can give the file name to download to as @Y{-}, in which case\n")
 			fmt.Printf("  the contents of the file will be printed to standard output, which\n")
 			fmt.Printf("  behaves identically to @W{s3 cat}.\n\n")
@@ -651,18 +1192,48 @@ func main() {
 			bail(fmt.Errorf("missing required --bucket option."))
 		}
 
-		c, err := client()
+		if opts.Download.Range != "" {
+			if opts.Recursive {
+				bail(fmt.Errorf("--range cannot be used with -R."))
+			}
+			if opts.Download.Continue {
+				bail(fmt.Errorf("--range cannot be used with --continue."))
+			}
+			_, err := byterange(opts.Download.Range)
+			bail(err)
+		}
+		if opts.Download.Continue && opts.Download.To == "-" {
+			bail(fmt.Errorf("cannot continue a download to standard output."))
+		}
+		partSize, err := partSizeOption(opts.Download.PartSize)
 		bail(err)
 
-		out, err := c.Get(args[0])
+		c, err := client()
 		bail(err)
 
-		if opts.Download.To == "-" {
-			debugf("streaming @Y{%s}:@C{%s} to @G{standard output}", c.Bucket, args[0])
-			_, err = io.Copy(os.Stdout, out)
+		if opts.Recursive {
+			prefix := args[0]
+			if prefix != "" && !strings.HasSuffix(prefix, "/") {
+				prefix += "/"
+			}
+			if opts.Download.To == "" {
+				opts.Download.To = "."
+				if prefix != "" {
+					opts.Download.To = path.Base(prefix)
+				}
+				debugf("determined destination directory to be @C{%s}", opts.Download.To)
+			}
+			if opts.Download.To == "-" {
+				bail(fmt.Errorf("cannot recursively download to standard output."))
+			}
+
+			debugf("recursively downloading @Y{%s}:@C{%s} to @C{%s}", c.Bucket, prefix, opts.Download.To)
+			files, total, err := downloadAll(c, prefix, opts.Download.To, opts.Download.Parallel, opts.Download.Continue)
+			fmt.Printf("downloaded @G{%d} file(s), @G{%s} (%d bytes) in total.\n", files, s3.Bytes(total), total)
 			bail(err)
 			os.Exit(0)
 		}
+
 		if opts.Download.To == "" {
 			opts.Download.To = filepath.Base(args[0])
 			if opts.Download.To == "." {
@@ -671,11 +1242,12 @@ func main() {
 			debugf("determined destination file path to be @C{%s}", opts.Download.To)
 		}
 
-		debugf("downloading @Y{%s}:@C{%s} to @C{%s}", c.Bucket, args[0], opts.Download.To)
-		file, err := os.OpenFile(opts.Download.To, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0666)
-		bail(err)
-
-		_, err = io.Copy(file, out)
+		_, err = download(c, args[0], opts.Download.To, downloadOptions{
+			Range:    opts.Download.Range,
+			Continue: opts.Download.Continue,
+			Threads:  opts.Download.Parallel,
+			PartSize: partSize,
+		})
 		bail(err)
 		os.Exit(0)
 	}
@@ -703,6 +1275,11 @@ func main() {
 			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
 			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
 
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
 			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
 			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
 			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
@@ -711,6 +1288,19 @@ func main() {
 			fmt.Printf("  --bucket NAME   The name of the S3 bucket to search.\n")
 			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
 
+			fmt.Printf("  --range RANGE   Only print part of the file: bytes @W{FIRST-LAST}\n")
+			fmt.Printf("                  (counting from 0, inclusive), @W{FIRST-} through\n")
+			fmt.Printf("                  the end, or @W{-N}, the last N bytes.\n\n")
+
+			fmt.Printf("  --parallel N    How many parts of the file to download at once.\n")
+			fmt.Printf("  -n N            They are still printed in order.  Defaults to 2.\n")
+			fmt.Printf("                  Can be set via @W{$S3_THREADS=N}.\n\n")
+
+			fmt.Printf("  --part-size SIZE\n")
+			fmt.Printf("                  How big each of those parts is, i.e. @W{16M}.\n")
+			fmt.Printf("                  At most @W{N+1} parts are held in memory at once.\n")
+			fmt.Printf("                  Defaults to 8M.\n\n")
+
 			os.Exit(0)
 		}
 		if len(args) == 0 {
@@ -728,52 +1318,30 @@ func main() {
 			bail(fmt.Errorf("missing required --bucket option."))
 		}
 
-		c, err := client()
+		if opts.Cat.Range != "" {
+			_, err := byterange(opts.Cat.Range)
+			bail(err)
+		}
+		partSize, err := partSizeOption(opts.Cat.PartSize)
 		bail(err)
 
-		out, err := c.Get(args[0])
+		c, err := client()
 		bail(err)
 
-		debugf("streaming @Y{%s}:@C{%s} to @G{standard output}", c.Bucket, args[0])
-		_, err = io.Copy(os.Stdout, out)
+		_, err = download(c, args[0], "-", downloadOptions{
+			Range:    opts.Cat.Range,
+			Threads:  opts.Cat.Parallel,
+			PartSize: partSize,
+		})
 		bail(err)
 
 		os.Exit(0)
 	}
 
-	if command == "url" {
-		if opts.Help {
-			fmt.Printf("USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
-			fmt.Printf("@M{Generate an HTTPS URL for accessing a single file in a bucket}\n\n")
-			fmt.Printf("OPTIONS\n")
-			fmt.Printf("  --bucket NAME   The name of the S3 bucket that holds the file.\n")
-			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
-
-			os.Exit(0)
-		}
-		if len(args) == 0 {
-			fmt.Fprintf(os.Stderr, "@R{!!! missing path argument.}\n")
-			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
-			os.Exit(1)
-		}
-		if len(args) > 1 {
-			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
-			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
-			os.Exit(1)
-		}
-
-		if opts.Bucket == "" {
-			bail(fmt.Errorf("missing required --bucket option."))
-		}
-
-		fmt.Printf("https://%s.s3.amazonaws.com/%s\n", opts.Bucket, args[0])
-		os.Exit(0)
-	}
-
-	if command == "rm" {
+	if command == "stat" {
 		if opts.Help {
-			fmt.Printf("USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
-			fmt.Printf("@M{Removes a file from an S3 bucket}\n\n")
+			fmt.Printf("USAGE: @C{s3} @G{stat} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Printf("@M{Show the metadata (headers) of a file in S3}\n\n")
 			fmt.Printf("OPTIONS\n\n")
 			fmt.Printf("  --help, -h      Show this help screen.\n")
 			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
@@ -793,63 +1361,59 @@ func main() {
 			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
 			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
 
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
 			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
 			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
 			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
 			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
 
-			fmt.Printf("  --bucket NAME   The name of the S3 bucket to remove from.\n")
-			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket that holds the file,\n")
+			fmt.Printf("   -b NAME        if not given as an s3:// URL.\n")
+			fmt.Printf("                  Can be set via @W{$S3_BUCKET}.\n\n")
 
-			fmt.Printf("  -R              Recursively remove all of the files in the bucket\n")
-			fmt.Printf("                  under the given path.  @R{This is dangerous}.\n\n")
+			fmt.Printf("  --output FORMAT How to format the metadata: one of @W{table} (the\n")
+			fmt.Printf("  -o FORMAT       default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
 
 			os.Exit(0)
 		}
 		if len(args) == 0 {
 			fmt.Fprintf(os.Stderr, "@R{!!! missing path argument.}\n")
-			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{stat} [OPTIONS] @Y{remote/file/path}\n")
 			os.Exit(1)
 		}
 		if len(args) > 1 {
 			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
-			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{stat} [OPTIONS] @Y{remote/file/path}\n")
 			os.Exit(1)
 		}
 
-		if opts.Bucket == "" {
+		l := parseLocation(args[0])
+		if l.Bucket == "" {
 			bail(fmt.Errorf("missing required --bucket option."))
 		}
 
 		c, err := client()
 		bail(err)
 
-		if opts.Recursive {
-			root := strings.TrimSuffix(args[0], "/")
-			debugf("recursively deleting all files under @Y{%s}:@C{%s}", c.Bucket, args[0])
-
-			files, err := c.List()
-			bail(err)
-
-			for _, f := range files {
-				if strings.HasPrefix(f.Key, root+"/") {
-					debugf("  - deleting @R{%s}", f.Key)
-					bail(c.Delete(f.Key))
-				} else {
-					debugf("  - skipping @C{%s}", f.Key)
-				}
-			}
+		info, err := stat(c, l.Bucket, l.Key)
+		if err != nil {
+			bail(fmt.Errorf("%s:%s: %s", l.Bucket, l.Key, err))
 		}
-
-		debugf("deleting @Y{%s}:@C{%s}", c.Bucket, args[0])
-		bail(c.Delete(args[0]))
+		bail(info.print())
 		os.Exit(0)
 	}
 
-	if command == "ls" {
+	if command == "whoami" {
 		if opts.Help {
-			fmt.Printf("USAGE: @C{s3} @G{ls} [OPTIONS] -b @Y{BUCKET}\n")
-			fmt.Printf("@M{Print the contents of a remote S3 file to standard output}\n\n")
+			fmt.Printf("USAGE: @C{s3} @G{whoami} [OPTIONS]\n")
+			fmt.Printf("@M{Show the identity that s3 is acting as}\n\n")
+			fmt.Printf("Asks AWS STS (@W{GetCallerIdentity}) who the effective credentials belong\n")
+			fmt.Printf("to, after any @W{--assume-role}, and says where the credentials came from.\n\n")
 			fmt.Printf("OPTIONS\n\n")
 			fmt.Printf("  --help, -h      Show this help screen.\n")
 			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
@@ -862,64 +1426,1098 @@ func main() {
 			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
 			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
 
-			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
-			fmt.Printf("                  should be suitable for actual AWS S3.\n")
-			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+			fmt.Printf("  --session-token TOKEN\n")
+			fmt.Printf("                  The session token that goes with temporary\n")
+			fmt.Printf("                  credentials.  Can be set via @W{$S3_SESSION_TOKEN}.\n\n")
 
-			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("  --assume-role ARN\n")
+			fmt.Printf("                  Assume this IAM role first.  See `@W{s3 help}'.\n\n")
+
+			fmt.Printf("  --region, -r    The region to operate in.  Defaults to us-east-1.\n")
 			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
 
-			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
-			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
-			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
-			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+			fmt.Printf("  --sts-url URL   The URL of the STS API.  Defaults to the\n")
+			fmt.Printf("                  regional AWS endpoint.  Can be set via @W{$S3_STS_URL}.\n\n")
 
-			fmt.Printf("  --bucket NAME   The name of the S3 bucket to list.\n")
-			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+			fmt.Printf("  --output FORMAT How to format the identity: one of @W{table} (the\n")
+			fmt.Printf("  -o FORMAT       default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
 
 			os.Exit(0)
 		}
 		if len(args) > 0 {
 			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
-			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{ls} [OPTIONS] -b @Y{BUCKET}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{whoami} [OPTIONS]\n")
 			os.Exit(1)
 		}
 
-		if opts.Bucket == "" {
-			bail(fmt.Errorf("missing required --bucket option."))
-		}
-
 		c, err := client()
 		bail(err)
 
-		debugf("listing @Y{%s}:@C{*}", c.Bucket)
-		files, err := c.List()
+		id, err := whoami(c)
 		bail(err)
-
-		w := struct {
-			Key          int
-			LastModified int
-			OwnerName    int
-			ETag         int
-			Size         int
-		}{
-			Key:          len("file"),
-			LastModified: len("last modified"),
-			OwnerName:    len("owner"),
-			ETag:         len("etag"),
-			Size:         len("size"),
-		}
-		for _, f := range files {
-			w.Key = max(w.Key, len(f.Key))
-			w.LastModified = max(w.LastModified, len(fmt.Sprintf("%s", f.LastModified)))
-			w.OwnerName = max(w.OwnerName, len(f.OwnerName))
-			w.ETag = max(w.ETag, len(f.ETag))
-			w.Size = max(w.Size, len(fmt.Sprintf("%s", f.Size)))
-		}
-		fmt.Printf("%-*s  %-*s  %-*s  %-*s  %-*s\n", w.Key, "file", w.LastModified, "last modified", w.OwnerName, "owner", w.ETag, "etag", w.Size, "size")
-		for _, f := range files {
-			fmt.Printf("@G{%-*s}  %-*s  @M{%-*s}  @C{%-*s}  @Y{%-*s}\n", w.Key, f.Key, w.LastModified, f.LastModified, w.OwnerName, f.OwnerName, w.ETag, f.ETag, w.Size, f.Size)
+		bail(id.print(c))
+		os.Exit(0)
+	}
+
+	if command == "exists" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{exists} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Printf("@M{Check if a file exists in S3}\n\n")
+			fmt.Printf("Prints nothing; exits @G{0} if the file exists, @Y{1} if it doesn't, and\n")
+			fmt.Printf("@R{2} if something went wrong (bad credentials, no such bucket, etc.)\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket that holds the file,\n")
+			fmt.Printf("   -b NAME        if not given as an s3:// URL.\n")
+			fmt.Printf("                  Can be set via @W{$S3_BUCKET}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing path argument.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{exists} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{exists} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+
+		l := parseLocation(args[0])
+		if l.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		ok, err := exists(c, l.Bucket, l.Key)
+		bail(err)
+		if !ok {
+			debugf("@Y{%s}:@C{%s} does not exist", l.Bucket, l.Key)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if command == "verify" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{verify} [OPTIONS] @Y{local/file} @Y{remote/file/path}\n")
+			fmt.Printf("@M{Check that a local file matches a file in S3}\n\n")
+			fmt.Printf("Compares the local file with the remote one, without downloading\n")
+			fmt.Printf("it: first by size, then by MD5.  Files uploaded in parts don't have\n")
+			fmt.Printf("an MD5 (unless @G{s3} uploaded them), so their ETag is compared with\n")
+			fmt.Printf("what the local file's would be, with the same part size.\n\n")
+			fmt.Printf("Exits @G{0} if the files match, @Y{1} if they don't, and @R{2} if it couldn't\n")
+			fmt.Printf("tell (or if something went wrong).\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket that holds the file,\n")
+			fmt.Printf("   -b NAME        if not given as an s3:// URL.\n")
+			fmt.Printf("                  Can be set via @W{$S3_BUCKET}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! wrong number of arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{verify} [OPTIONS] @Y{local/file} @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+
+		l := parseLocation(args[1])
+		if l.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		result, why, err := verify(c, args[0], l.Bucket, l.Key)
+		bail(err)
+		switch result {
+		case verifiedSame:
+			fmt.Printf("@G{ok}: @C{%s} matches @Y{%s}:@C{%s} (%s)\n", args[0], l.Bucket, l.Key, why)
+			os.Exit(0)
+		case verifiedDifferent:
+			fmt.Printf("@R{MISMATCH}: @C{%s} does not match @Y{%s}:@C{%s} (%s)\n", args[0], l.Bucket, l.Key, why)
+			os.Exit(1)
+		default:
+			fmt.Printf("@Y{unknown}: unable to verify @C{%s} against @Y{%s}:@C{%s} (%s)\n", args[0], l.Bucket, l.Key, why)
+			os.Exit(2)
+		}
+	}
+
+	if command == "cp" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{cp} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			fmt.Printf("       @C{s3} @G{cp} -R [OPTIONS] @Y{source/prefix/} @Y{destination/prefix/}\n")
+			fmt.Printf("@M{Copy a file (or everything under a prefix), server-side}\n\n")
+			fmt.Printf("@Y{SOURCE} and @Y{DESTINATION} are either keys in the @W{--bucket} bucket, or\n")
+			fmt.Printf("S3 URLs, like @Y{s3://other-bucket/path/to/file}, for copying between\n")
+			fmt.Printf("buckets.  The data never leaves S3.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to copy within, for\n")
+			fmt.Printf("   -b NAME        keys that aren't given as s3:// URLs.\n")
+			fmt.Printf("                  Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  -R              Recursively copy every file under the source\n")
+			fmt.Printf("                  prefix to the destination prefix.\n\n")
+
+			fmt.Printf("  --metadata-directive COPY|REPLACE\n")
+			fmt.Printf("                  Whether to keep the metadata of the source file\n")
+			fmt.Printf("                  (@W{COPY}, the default), or replace it (@W{REPLACE}).\n\n")
+
+			fmt.Printf("  --content-type  The MIME Content-Type to give the copy, with\n")
+			fmt.Printf("  -t TYPE         @W{--metadata-directive REPLACE}.\n\n")
+
+			fmt.Printf("  --max-keys N    With @W{-R}, stop after listing @W{N} keys, and print\n")
+			fmt.Printf("                  the @W{--continuation-token} needed to pick up from there.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  With @W{-R}, only copy keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a recursive copy that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing source / destination arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{cp} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+		if len(args) > 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{cp} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+
+		directive, err := metadataDirective(opts.Copy.MetadataDirective)
+		bail(err)
+
+		src, dst := parseLocation(args[0]), parseLocation(args[1])
+		if src.Bucket == "" || dst.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		cp := copier{c: c, directive: directive, headers: http.Header{}}
+		if opts.Copy.ContentType != "" {
+			if directive != "REPLACE" {
+				bail(fmt.Errorf("--content-type only makes sense with --metadata-directive REPLACE."))
+			}
+			cp.headers.Set("Content-Type", opts.Copy.ContentType)
+		}
+
+		if opts.Recursive {
+			for _, l := range []*location{&src, &dst} {
+				if l.Key != "" && !strings.HasSuffix(l.Key, "/") {
+					l.Key += "/"
+				}
+			}
+			debugf("recursively copying %s to %s", src, dst)
+			n, err := cp.all(src, dst)
+			if n > 0 || err == nil {
+				fmt.Printf("copied @G{%d} file(s).\n", n)
+			}
+			bail(err)
+			os.Exit(0)
+		}
+
+		if dst.Key == "" || strings.HasSuffix(dst.Key, "/") {
+			dst.Key += path.Base(src.Key)
+		}
+		bail(cp.one(src, dst))
+		os.Exit(0)
+	}
+
+	if command == "mv" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{mv} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			fmt.Printf("       @C{s3} @G{mv} -R [OPTIONS] @Y{source/prefix/} @Y{destination/prefix/}\n")
+			fmt.Printf("@M{Move (rename) a file, or everything under a prefix}\n\n")
+			fmt.Printf("@Y{SOURCE} and @Y{DESTINATION} are either keys in the @W{--bucket} bucket, or\n")
+			fmt.Printf("S3 URLs, like @Y{s3://other-bucket/path/to/file}.  Each file is copied,\n")
+			fmt.Printf("server-side, and only deleted from its original location once the\n")
+			fmt.Printf("copy has been confirmed.  If anything goes wrong, @G{s3} lists which\n")
+			fmt.Printf("files were moved, and which were left in place.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to move within, for\n")
+			fmt.Printf("   -b NAME        keys that aren't given as s3:// URLs.\n")
+			fmt.Printf("                  Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  -R              Recursively move every file under the source\n")
+			fmt.Printf("                  prefix to the destination prefix.\n\n")
+
+			fmt.Printf("  --metadata-directive COPY|REPLACE\n")
+			fmt.Printf("                  Whether to keep the metadata of the source file\n")
+			fmt.Printf("                  (@W{COPY}, the default), or replace it (@W{REPLACE}).\n\n")
+
+			fmt.Printf("  --content-type  The MIME Content-Type to give the moved file, with\n")
+			fmt.Printf("  -t TYPE         @W{--metadata-directive REPLACE}.\n\n")
+
+			fmt.Printf("  --max-keys N    With @W{-R}, stop after listing @W{N} keys, and print\n")
+			fmt.Printf("                  the @W{--continuation-token} needed to pick up from there.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  With @W{-R}, only move keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a recursive move that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing source / destination arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{mv} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+		if len(args) > 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{mv} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+
+		directive, err := metadataDirective(opts.Move.MetadataDirective)
+		bail(err)
+
+		src, dst := parseLocation(args[0]), parseLocation(args[1])
+		if src.Bucket == "" || dst.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		mv := &mover{copier: copier{c: c, directive: directive, headers: http.Header{}}}
+		if opts.Move.ContentType != "" {
+			if directive != "REPLACE" {
+				bail(fmt.Errorf("--content-type only makes sense with --metadata-directive REPLACE."))
+			}
+			mv.headers.Set("Content-Type", opts.Move.ContentType)
+		}
+
+		if opts.Recursive {
+			for _, l := range []*location{&src, &dst} {
+				if l.Key != "" && !strings.HasSuffix(l.Key, "/") {
+					l.Key += "/"
+				}
+			}
+			debugf("recursively moving %s to %s", src, dst)
+			err := mv.all(src, dst)
+			if mv.failed() {
+				mv.report()
+				if err == nil {
+					err = fmt.Errorf("%d file(s) could not be moved", len(mv.left))
+				}
+			} else if err == nil {
+				fmt.Printf("moved @G{%d} file(s).\n", len(mv.moved))
+			}
+			bail(err)
+			os.Exit(0)
+		}
+
+		if dst.Key == "" || strings.HasSuffix(dst.Key, "/") {
+			dst.Key += path.Base(src.Key)
+		}
+		bail(mv.one(src, dst))
+		os.Exit(0)
+	}
+
+	if command == "sync" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{sync} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			fmt.Printf("@M{Synchronize a local directory with a prefix in an S3 bucket}\n\n")
+			fmt.Printf("One of @Y{SOURCE} or @Y{DESTINATION} must be a local directory, and the\n")
+			fmt.Printf("other an S3 URL, like @Y{s3://bucket/some/prefix}.  Files that are\n")
+			fmt.Printf("missing from the destination, or differ (by size, MD5 / ETag, or\n")
+			fmt.Printf("modification time) are copied from the source.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --parallel N    How many parallel I/O threads to spin up for each\n")
+			fmt.Printf("  -n N            file that is uploaded or downloaded.  Defaults to 2.\n")
+			fmt.Printf("                  Can be set via @W{$S3_THREADS=N}.\n\n")
+
+			fmt.Printf("  --part-size SIZE\n")
+			fmt.Printf("                  How big each part of a (multipart) upload or\n")
+			fmt.Printf("                  (ranged) download is, between 5M and 5G.  Uploads\n")
+			fmt.Printf("                  default to 5M, and downloads to 8M.\n\n")
+
+			fmt.Printf("  --delete        Delete files from the destination that don't\n")
+			fmt.Printf("                  exist in the source.  @R{This is dangerous}.\n\n")
+
+			fmt.Printf("  --dry-run       Print what would be copied (or deleted), without\n")
+			fmt.Printf("                  actually copying (or deleting) anything.\n\n")
+
+			fmt.Printf("  --include GLOB  Only synchronize files whose relative path (or\n")
+			fmt.Printf("                  base name) matches @W{GLOB}.  A @W{*} only matches\n")
+			fmt.Printf("                  within one directory, but @W{dir/} or @W{dir/*} matches\n")
+			fmt.Printf("                  everything under @W{dir}.  Can be given more than once.\n\n")
+
+			fmt.Printf("  --exclude GLOB  Skip files whose relative path (or base name)\n")
+			fmt.Printf("                  matches @W{GLOB}.  Can be given more than once,\n")
+			fmt.Printf("                  and takes precedence over @W{--include}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing source / destination arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{sync} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+		if len(args) > 2 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{sync} [OPTIONS] @Y{SOURCE} @Y{DESTINATION}\n")
+			os.Exit(1)
+		}
+
+		partSize, err := partSizeOption(opts.Sync.PartSize)
+		bail(err)
+		if partSize != 0 && (partSize < uploadPartSize || partSize > maxPartSize) {
+			bail(fmt.Errorf("invalid --part-size '%s' (must be between 5M and 5G)", opts.Sync.PartSize))
+		}
+
+		s := syncer{
+			filter: filter{
+				Include: opts.Sync.Include,
+				Exclude: opts.Sync.Exclude,
+			},
+			delete:   opts.Sync.Delete,
+			dryRun:   opts.Sync.DryRun,
+			parallel: opts.Sync.Parallel,
+			partSize: partSize,
+		}
+
+		srcBucket, srcPrefix, srcRemote := parseS3URL(args[0])
+		dstBucket, dstPrefix, dstRemote := parseS3URL(args[1])
+		if srcRemote == dstRemote {
+			bail(fmt.Errorf("exactly one of the source and destination must be an s3://bucket/prefix URL."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		if srcRemote {
+			c.Bucket, s.prefix, s.dir = srcBucket, srcPrefix, args[1]
+		} else {
+			c.Bucket, s.prefix, s.dir = dstBucket, dstPrefix, args[0]
+		}
+		if c.Bucket == "" {
+			bail(fmt.Errorf("missing bucket name in s3:// URL."))
+		}
+		if s.prefix != "" && !strings.HasSuffix(s.prefix, "/") {
+			s.prefix += "/"
+		}
+		s.c = c
+
+		if srcRemote {
+			debugf("syncing @Y{%s}:@C{%s} down to @C{%s}", c.Bucket, s.prefix, s.dir)
+			bail(s.down())
+		} else {
+			debugf("syncing @C{%s} up to @Y{%s}:@C{%s}", s.dir, c.Bucket, s.prefix)
+			bail(s.up())
+		}
+		os.Exit(0)
+	}
+
+	if command == "uploads" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{uploads} [OPTIONS] @Y{ls} [@Y{prefix/}]\n")
+			fmt.Printf("       @C{s3} @G{uploads} [OPTIONS] @Y{parts} @Y{KEY} @Y{UPLOAD-ID}\n")
+			fmt.Printf("       @C{s3} @G{uploads} [OPTIONS] @Y{abort} @Y{KEY} @Y{UPLOAD-ID}\n")
+			fmt.Printf("       @C{s3} @G{uploads} [OPTIONS] @Y{prune} --older-than @Y{AGE} [@Y{prefix/}]\n")
+			fmt.Printf("@M{Manage unfinished multipart uploads}\n\n")
+			fmt.Printf("Multipart uploads that are interrupted, and never resumed (see\n")
+			fmt.Printf("`@W{s3 put --resume}'), are kept by S3 (and billed for) until they\n")
+			fmt.Printf("are aborted.  @Y{ls} lists them, with when they were started, and how\n")
+			fmt.Printf("many parts (and bytes) they have so far; @Y{parts} lists the parts of\n")
+			fmt.Printf("one of them.  @Y{abort} throws one away, and @Y{prune} throws away all\n")
+			fmt.Printf("of the ones that were started more than @Y{AGE} ago, which is handy\n")
+			fmt.Printf("to run from cron.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to look in.\n")
+			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  --output FORMAT How to format @Y{ls} and @Y{parts}: one of @W{table}\n")
+			fmt.Printf("  -o FORMAT       (the default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
+
+			fmt.Printf("  --older-than AGE\n")
+			fmt.Printf("                  With @Y{prune}, only abort uploads that were started\n")
+			fmt.Printf("                  more than @W{AGE} ago, i.e. @W{36h} or @W{7d}.  Required,\n")
+			fmt.Printf("                  so that uploads still in progress are left alone.\n\n")
+
+			fmt.Printf("  --dry-run       With @Y{prune}, show which uploads would be aborted,\n")
+			fmt.Printf("                  without actually aborting them.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing sub-command (ls, parts, abort, or prune).}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{uploads} @Y{ls}|@Y{parts}|@Y{abort}|@Y{prune} ...\n")
+			os.Exit(1)
+		}
+
+		if opts.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		switch args[0] {
+		case "ls", "list":
+			if len(args) > 2 {
+				bail(fmt.Errorf("too many arguments."))
+			}
+			prefix := ""
+			if len(args) == 2 {
+				prefix = args[1]
+			}
+
+			c, err := client()
+			bail(err)
+
+			r := render(
+				column{Field: "key", Header: "key", Color: "C"},
+				column{Field: "upload_id", Header: "upload id", Color: "M"},
+				column{Field: "initiated", Header: "initiated"},
+				column{Field: "parts", Header: "parts"},
+				column{Field: "size", Header: "size"},
+			)
+			bail(listUploads(c, c.Bucket, prefix, func(uploads []inflight) error {
+				for _, u := range uploads {
+					parts, err := u.multipart(c, c.Bucket).listParts()
+					if err == errNoSuchUpload {
+						continue // finished (or aborted) since we listed it.
+					}
+					if err != nil {
+						return err
+					}
+					var size int64
+					for _, p := range parts {
+						size += p.Size
+					}
+					r.Row(u.Key, u.UploadID, u.Initiated, len(parts), s3.Bytes(size))
+				}
+				r.Flush()
+				return nil
+			}))
+			bail(r.Close())
+
+		case "parts":
+			if len(args) != 3 {
+				fmt.Fprintf(os.Stderr, "@R{!!! wrong number of arguments.}\n")
+				fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{uploads} @Y{parts} @Y{KEY} @Y{UPLOAD-ID}\n")
+				os.Exit(1)
+			}
+
+			c, err := client()
+			bail(err)
+
+			m := &multipart{c: c, Bucket: c.Bucket, Key: args[1], ID: args[2]}
+			parts, err := m.listParts()
+			if err == errNoSuchUpload {
+				bail(fmt.Errorf("no such upload '%s' for %s:%s", args[2], c.Bucket, args[1]))
+			}
+			bail(err)
+
+			r := render(
+				column{Field: "part", Header: "part", Color: "W"},
+				column{Field: "last_modified", Header: "last modified"},
+				column{Field: "etag", Header: "etag"},
+				column{Field: "size", Header: "size"},
+			)
+			for _, p := range parts {
+				r.Row(p.PartNumber, p.LastModified, strings.Trim(p.ETag, `"`), s3.Bytes(p.Size))
+			}
+			bail(r.Close())
+
+		case "abort":
+			if len(args) != 3 {
+				fmt.Fprintf(os.Stderr, "@R{!!! wrong number of arguments.}\n")
+				fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{uploads} @Y{abort} @Y{KEY} @Y{UPLOAD-ID}\n")
+				os.Exit(1)
+			}
+
+			c, err := client()
+			bail(err)
+
+			m := &multipart{c: c, Bucket: c.Bucket, Key: args[1], ID: args[2]}
+			bail(m.abort())
+			fmt.Printf("aborted upload @M{%s} of @Y{%s}:@C{%s}\n", m.ID, c.Bucket, m.Key)
+
+		case "prune":
+			if len(args) > 2 {
+				bail(fmt.Errorf("too many arguments."))
+			}
+			prefix := ""
+			if len(args) == 2 {
+				prefix = args[1]
+			}
+			if opts.Uploads.OlderThan == "" {
+				bail(fmt.Errorf("missing required --older-than option."))
+			}
+			age, err := duration(opts.Uploads.OlderThan)
+			bail(err)
+
+			c, err := client()
+			bail(err)
+
+			verb := "aborted"
+			if opts.Uploads.DryRun {
+				verb = "would abort"
+			}
+			pruned, failed, err := pruneUploads(c, c.Bucket, prefix, time.Now().Add(-age), opts.Uploads.DryRun, func(u inflight, err error) {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "@R{!!! unable to abort upload %s of %s: %s}\n", u.UploadID, u.Key, err)
+					return
+				}
+				fmt.Printf("%s upload @M{%s} of @C{%s} (started %s)\n", verb, u.UploadID, u.Key, u.Initiated.Local().Format("2006-01-02 15:04:05"))
+			})
+			bail(err)
+
+			fmt.Printf("%s @G{%d} upload(s) started more than %s ago.\n", verb, pruned, opts.Uploads.OlderThan)
+			if failed > 0 {
+				bail(fmt.Errorf("%d upload(s) could not be aborted.", failed))
+			}
+
+		default:
+			bail(fmt.Errorf("unrecognized sub-command '%s' (must be ls, parts, abort, or prune)", args[0]))
+		}
+		os.Exit(0)
+	}
+
+	if command == "url" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Printf("@M{Generate a presigned URL for accessing a single file in a bucket}\n\n")
+			fmt.Printf("Anyone with the URL can make the request it was signed for, without\n")
+			fmt.Printf("any credentials of their own, until the URL expires.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket that holds the file.\n")
+			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  --method, -X    The HTTP method the URL is good for: one of\n")
+			fmt.Printf("                  @W{GET} (the default), @W{PUT}, @W{HEAD}, or @W{DELETE}.\n\n")
+
+			fmt.Printf("  --expires, -e   How long the URL should be valid for, i.e. @W{15m}\n")
+			fmt.Printf("                  (the default), @W{12h}, or @W{7d} (the longest allowed).\n\n")
+
+			fmt.Printf("  --response-content-type VALUE\n")
+			fmt.Printf("  --response-content-disposition VALUE\n")
+			fmt.Printf("  --response-content-encoding VALUE\n")
+			fmt.Printf("  --response-content-language VALUE\n")
+			fmt.Printf("  --response-cache-control VALUE\n")
+			fmt.Printf("  --response-expires VALUE\n")
+			fmt.Printf("                  Override the corresponding header in the response\n")
+			fmt.Printf("                  to a @W{GET} or @W{HEAD} via the URL.  For example,\n")
+			fmt.Printf("                  @W{--response-content-disposition attachment} makes\n")
+			fmt.Printf("                  browsers download the file, instead of showing it.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing path argument.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{url} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+
+		if opts.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		method := strings.ToUpper(opts.GenerateURL.Method)
+		switch method {
+		case "GET", "PUT", "HEAD", "DELETE":
+		default:
+			bail(fmt.Errorf("invalid --method '%s' (must be GET, PUT, HEAD, or DELETE)", opts.GenerateURL.Method))
+		}
+
+		expires, err := duration(opts.GenerateURL.Expires)
+		bail(err)
+		if expires <= 0 || expires > maxPresign {
+			bail(fmt.Errorf("invalid --expires '%s' (must be between 1s and 7d)", opts.GenerateURL.Expires))
+		}
+
+		q := url.Values{}
+		for k, v := range map[string]string{
+			"response-content-type":        opts.GenerateURL.ContentType,
+			"response-content-disposition": opts.GenerateURL.ContentDisposition,
+			"response-content-encoding":    opts.GenerateURL.ContentEncoding,
+			"response-content-language":    opts.GenerateURL.ContentLanguage,
+			"response-cache-control":       opts.GenerateURL.CacheControl,
+			"response-expires":             opts.GenerateURL.ResponseExpires,
+		} {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+
+		c, err := client()
+		bail(err)
+
+		debugf("presigning @G{%s} of @Y{%s}:@C{%s}, valid for @W{%s}", method, c.Bucket, args[0], expires)
+		fmt.Printf("%s\n", presign(c, method, c.Bucket, args[0], q, expires, time.Now().UTC()))
+		os.Exit(0)
+	}
+
+	if command == "post-policy" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{post-policy} [OPTIONS]\n")
+			fmt.Printf("@M{Sign a POST policy, for uploading files to a bucket from a browser}\n\n")
+			fmt.Printf("Prints the URL and form fields (as JSON) that an HTML form needs to\n")
+			fmt.Printf("upload a file straight to the bucket, or (with @W{--html}) the form itself.\n")
+			fmt.Printf("The file has to be the last field in the form, named @W{file}.\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to upload to.\n")
+			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  --key-prefix PREFIX\n")
+			fmt.Printf("                  Only allow uploads to keys that start with @W{PREFIX}.\n")
+			fmt.Printf("                  By default, any key in the bucket is allowed.\n\n")
+
+			fmt.Printf("  --max-size SIZE Only allow files up to @W{SIZE} (i.e. @W{100M}) in size.\n\n")
+
+			fmt.Printf("  --content-type  Only allow files of the given MIME type.  A trailing\n")
+			fmt.Printf("  -t TYPE         @W{*} (as in @W{image/*}) allows any type with that prefix;\n")
+			fmt.Printf("                  the uploader supplies the actual @W{Content-Type}, so\n")
+			fmt.Printf("                  the JSON lists it under @W{required}, and the HTML\n")
+			fmt.Printf("                  form has a field for it (filled in from the file).\n\n")
+
+			fmt.Printf("  --expires, -e   How long the policy should be valid for, i.e. @W{1h}\n")
+			fmt.Printf("                  (the default), @W{12h}, or @W{7d} (the longest allowed).\n\n")
+
+			fmt.Printf("  --html          Print a ready-to-use HTML form, instead of JSON.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) > 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{post-policy} [OPTIONS]\n")
+			os.Exit(1)
+		}
+
+		if opts.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		p := postPolicy{
+			Bucket:      opts.Bucket,
+			KeyPrefix:   opts.PostPolicy.KeyPrefix,
+			ContentType: opts.PostPolicy.ContentType,
+		}
+
+		var err error
+		p.Expires, err = duration(opts.PostPolicy.Expires)
+		bail(err)
+		if p.Expires <= 0 || p.Expires > maxPresign {
+			bail(fmt.Errorf("invalid --expires '%s' (must be between 1s and 7d)", opts.PostPolicy.Expires))
+		}
+		if opts.PostPolicy.MaxSize != "" {
+			p.MaxSize, err = bytesize(opts.PostPolicy.MaxSize)
+			bail(err)
+		}
+
+		c, err := client()
+		bail(err)
+
+		form, err := p.sign(c, time.Now().UTC())
+		bail(err)
+		if opts.PostPolicy.HTML {
+			bail(form.writeHTML(os.Stdout))
+		} else {
+			bail(form.writeJSON(os.Stdout))
+		}
+		os.Exit(0)
+	}
+
+	if command == "rm" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
+			fmt.Printf("@M{Removes a file from an S3 bucket}\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to remove from.\n")
+			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  -R              Recursively remove all of the files in the bucket\n")
+			fmt.Printf("                  under the given path.  @R{This is dangerous}.\n\n")
+
+			fmt.Printf("  --max-keys N    Stop after listing @W{N} keys, and print the\n")
+			fmt.Printf("                  @W{--continuation-token} needed to pick up from there.\n")
+			fmt.Printf("                  With this (or @W{--start-after}), the path itself\n")
+			fmt.Printf("                  is left alone, since there may be more under it.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  Only consider keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a listing that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "@R{!!! missing path argument.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{rm} [OPTIONS] @Y{remote/file/path}\n")
+			os.Exit(1)
+		}
+
+		if opts.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
+		}
+
+		c, err := client()
+		bail(err)
+
+		if opts.Recursive {
+			root := strings.TrimSuffix(args[0], "/")
+			debugf("recursively deleting all files under @Y{%s}:@C{%s}", c.Bucket, args[0])
+
+			l, n := newListing(c.Bucket, root+"/"), 0
+			bail(l.eachObject(c, func(f s3.Object) error {
+				debugf("  - deleting @R{%s}", f.Key)
+				n++
+				return signer(c).Delete(f.Key)
+			}))
+			if !l.whole(n) {
+				fmt.Printf("deleted @G{%d} file(s); there may still be files under @C{%s}, so it was not deleted.\n", n, args[0])
+				os.Exit(0)
+			}
+		}
+
+		debugf("deleting @Y{%s}:@C{%s}", c.Bucket, args[0])
+		bail(signer(c).Delete(args[0]))
+		os.Exit(0)
+	}
+
+	if command == "ls" {
+		if opts.Help {
+			fmt.Printf("USAGE: @C{s3} @G{ls} [OPTIONS] -b @Y{BUCKET} [@Y{prefix/}]\n")
+			fmt.Printf("@M{List the files in an S3 bucket, or under a prefix}\n\n")
+			fmt.Printf("OPTIONS\n\n")
+			fmt.Printf("  --help, -h      Show this help screen.\n")
+			fmt.Printf("  --version, -v   Print @G{s3} version information, then exit.\n")
+			fmt.Printf("  --debug, -D     Enable verbose logging of what @G{s3} is doing.\n")
+			fmt.Printf("  --trace, -T     Enable HTTP tracing of S3 communication.\n\n")
+
+			fmt.Printf("  --aki KEY-ID    The Amazon Key ID to use.  Can be set via\n")
+			fmt.Printf("                  the @W{$S3_AKI} environment variable.\n\n")
+
+			fmt.Printf("  --key SECRET    The Amazon Secret Key to use.  Can be set\n")
+			fmt.Printf("                  via the @W{$S3_KEY} environment variable.\n\n")
+
+			fmt.Printf("  --s3-url URL    The full URL to your S3 system.  The default\n")
+			fmt.Printf("                  should be suitable for actual AWS S3.\n")
+			fmt.Printf("                  Can be set via @W{$S3_URL}.\n\n")
+
+			fmt.Printf("  --region, -r    The S3 region to operate in.  Defaults to us-east-1.\n")
+			fmt.Printf("                  Can be set via @W{$S3_REGION}.\n\n")
+
+			fmt.Printf("  --no-sign-request\n")
+			fmt.Printf("                  Send unsigned requests, to read from a public\n")
+			fmt.Printf("                  bucket without credentials.  Can be set via\n")
+			fmt.Printf("                  @W{$S3_ANONYMOUS=yes}.\n\n")
+
+			fmt.Printf("  --path-buckets  Use path-based addressing for buckets.\n")
+			fmt.Printf("  -P              By default, @G{s3} uses DNS (name) based bucket\n")
+			fmt.Printf("                  addressing, which confuses some S3 work-alikes.\n")
+			fmt.Printf("                  Can be set via @W{$S3_USE_PATH=yes}.\n\n")
+
+			fmt.Printf("  --bucket NAME   The name of the S3 bucket to list.\n")
+			fmt.Printf("   -b NAME        Can be set via @W{$S3_BUCKET}.\n\n")
+
+			fmt.Printf("  --delimiter D   Roll up keys that share a prefix ending in @W{D}\n")
+			fmt.Printf("  -d D            into a single entry, like a sub-directory.\n")
+			fmt.Printf("                  Defaults to @W{/}, unless @W{-R} is given.\n\n")
+
+			fmt.Printf("  -R              Recursively list all of the files under the\n")
+			fmt.Printf("                  given prefix, as a flat list of keys.\n\n")
+
+			fmt.Printf("  --max-keys N    Stop after listing @W{N} keys, and print the\n")
+			fmt.Printf("                  @W{--continuation-token} needed to pick up from there.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  Only consider keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a listing that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
+			fmt.Printf("  --output FORMAT How to format the listing: one of @W{table} (the\n")
+			fmt.Printf("  -o FORMAT       default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
+
+			os.Exit(0)
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "@R{!!! too many arguments.}\n")
+			fmt.Fprintf(os.Stderr, "USAGE: @C{s3} @G{ls} [OPTIONS] -b @Y{BUCKET} [@Y{prefix/}]\n")
+			os.Exit(1)
+		}
+
+		if opts.Bucket == "" {
+			bail(fmt.Errorf("missing required --bucket option."))
 		}
+
+		c, err := client()
+		bail(err)
+
+		prefix := ""
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+		l := newListing(c.Bucket, prefix)
+		l.Delimiter = opts.List.Delimiter
+		if opts.Recursive {
+			l.Delimiter = ""
+		} else if l.Delimiter == "" {
+			l.Delimiter = "/"
+		}
+
+		debugf("listing @Y{%s}:@C{%s*} (delimiter '@C{%s}')", c.Bucket, l.Prefix, l.Delimiter)
+		r := render(lsColumns...)
+		bail(l.walk(c, func(p page) error {
+			files, prefixes := p.Objects, p.Prefixes
+			for len(files) > 0 || len(prefixes) > 0 {
+				if len(prefixes) > 0 && (len(files) == 0 || prefixes[0] < files[0].Key) {
+					r.Row(prefixes[0], "prefix", nil, nil, nil, nil)
+					prefixes = prefixes[1:]
+					continue
+				}
+				f := files[0]
+				r.Row(f.Key, "object", f.LastModified, f.OwnerName, f.ETag, f.Size)
+				files = files[1:]
+			}
+			r.Flush()
+			return nil
+		}))
+		bail(r.Close())
 		os.Exit(0)
 	}
 
@@ -957,6 +2555,16 @@ func main() {
 			fmt.Printf("  -R              Recursively change acls of the files in the bucket\n")
 			fmt.Printf("                  under the given path.  @R{This is dangerous}.\n\n")
 
+			fmt.Printf("  --max-keys N    Stop after listing @W{N} keys, and print the\n")
+			fmt.Printf("                  @W{--continuation-token} needed to pick up from there.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  Only consider keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a listing that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
 			os.Exit(0)
 		}
 		if len(args) == 0 {
@@ -990,21 +2598,18 @@ func main() {
 			root := strings.TrimSuffix(path, "/")
 			debugf("recursively changing the acl of all files under @Y{%s}:@C{%s}", c.Bucket, root)
 
-			files, err := c.List()
-			bail(err)
-
-			for _, f := range files {
-				if strings.HasPrefix(f.Key, root+"/") {
-					debugf("  - chacl @Y{%s} @C{%s}", f.Key, acl)
-					bail(c.ChangeACL(f.Key, acl))
-				} else {
-					debugf("  - skipping @C{%s}", f.Key)
-				}
+			prefix := ""
+			if root != "" {
+				prefix = root + "/"
 			}
+			bail(newListing(c.Bucket, prefix).eachObject(c, func(f s3.Object) error {
+				debugf("  - chacl @Y{%s} @C{%s}", f.Key, acl)
+				return signer(c).ChangeACL(f.Key, acl)
+			}))
 		}
 
 		debugf("chacl @Y{%s} @C{%s}", path, acl)
-		bail(c.ChangeACL(path, acl))
+		bail(signer(c).ChangeACL(path, acl))
 		os.Exit(0)
 	}
 
@@ -1042,6 +2647,20 @@ func main() {
 			fmt.Printf("  -R              Recursively list acls of the files in the bucket\n")
 			fmt.Printf("                  under the given path.\n\n")
 
+			fmt.Printf("  --max-keys N    Stop after listing @W{N} keys, and print the\n")
+			fmt.Printf("                  @W{--continuation-token} needed to pick up from there.\n\n")
+
+			fmt.Printf("  --start-after KEY\n")
+			fmt.Printf("                  Only consider keys that sort after @W{KEY}.\n\n")
+
+			fmt.Printf("  --continuation-token TOKEN\n")
+			fmt.Printf("                  Resume a listing that was interrupted (via ^C)\n")
+			fmt.Printf("                  or cut short by @W{--max-keys}.\n\n")
+
+			fmt.Printf("  --output FORMAT How to format the listing: one of @W{table} (the\n")
+			fmt.Printf("  -o FORMAT       default), @W{json}, @W{jsonl}, @W{yaml}, @W{csv}, or @W{tsv}.\n")
+			fmt.Printf("                  Can be set via @W{$S3_OUTPUT}.\n\n")
+
 			os.Exit(0)
 		}
 		if len(args) == 0 {
@@ -1071,26 +2690,29 @@ func main() {
 			root := strings.TrimSuffix(path, "/")
 			debugf("recursively retrieving the acl of all files under @Y{%s}:@C{%s}", c.Bucket, root)
 
-			files, err := c.List()
-			bail(err)
-
-			w := 0
-			for _, f := range files {
-				w = max(w, len(f.Key))
-			}
-			for _, f := range files {
-				if root == "" || f.Key == root || strings.HasPrefix(f.Key, root+"/") {
-					acl, err := c.GetACL(f.Key)
-					bail(err)
-					printacl(w, f.Key, acl)
+			r := renderACL()
+			bail(newListing(c.Bucket, root).walk(c, func(p page) error {
+				for _, f := range p.Objects {
+					if root == "" || f.Key == root || strings.HasPrefix(f.Key, root+"/") {
+						acl, err := signer(c).GetACL(f.Key)
+						if err != nil {
+							return err
+						}
+						printacl(r, f.Key, acl)
+					}
 				}
-			}
+				r.Flush()
+				return nil
+			}))
+			bail(r.Close())
 			os.Exit(0)
 		}
 
-		acl, err := c.GetACL(path)
+		acl, err := signer(c).GetACL(path)
 		bail(err)
-		printacl(len(path), path, acl)
+		r := renderACL()
+		printacl(r, path, acl)
+		bail(r.Close())
 		os.Exit(0)
 	}
 
@@ -1103,22 +2725,38 @@ func main() {
 	os.Exit(1)
 }
 
-func printacl(width int, file string, acl s3.ACL) {
-	for i, grant := range acl {
-		if i == 0 {
-			fmt.Printf("@Y{%*s}  ", width, file)
-		} else {
-			fmt.Printf("%*s  ", width, "")
-		}
+// lsColumns are the fields of an `s3 ls` listing.
+var lsColumns = []column{
+	{Field: "key", Header: "file", Color: "G"},
+	{Field: "type", Header: "type", Color: "M"},
+	{Field: "last_modified", Header: "last modified"},
+	{Field: "owner", Header: "owner", Color: "M"},
+	{Field: "etag", Header: "etag", Color: "C"},
+	{Field: "size", Header: "size", Color: "Y"},
+}
+
+func renderACL() renderer {
+	return render(
+		column{Field: "key", Header: "file", Color: "Y"},
+		column{Field: "grantee_type", Header: "type", Color: "M"},
+		column{Field: "grantee", Header: "grantee", Color: "C"},
+		column{Field: "permission", Header: "permission", Color: "G"},
+	)
+}
+
+func printacl(r renderer, file string, acl s3.ACL) {
+	for _, grant := range acl {
 		if grant.GranteeName != "" {
-			fmt.Printf("@M{user}  @C{%s} has @G{%s}\n", grant.GranteeName, grant.Permission)
+			r.Row(file, "user", grant.GranteeName, grant.Permission)
 		} else {
-			fmt.Printf("@M{group} @C{%s} has @G{%s}\n", grant.Group, grant.Permission)
+			r.Row(file, "group", grant.Group, grant.Permission)
 		}
 	}
 
-	if len(acl) == 0 {
-		fmt.Printf("%s  (no grants in acl)\n", file)
+	// machine-readable output just has no rows for an empty acl,
+	// but a table would otherwise leave the file out entirely.
+	if len(acl) == 0 && opts.Output == "table" {
+		r.Row(file, nil, "(no grants in acl)", nil)
 	}
 }
 
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// A mover moves objects, by copying them (server-side) and then
+// deleting the originals.  A source object is only ever deleted once
+// its copy has been confirmed, and the mover keeps track of which
+// objects it has moved and which it had to leave where they were,
+// so that a partial failure can be reported on.
+type mover struct {
+	copier
+
+	moved []string
+	left  []string
+}
+
+// move moves a single object, of the given size.  Failures are
+// returned, and recorded for report().
+func (mv *mover) move(src, dst location, size int64) error {
+	fmt.Printf("@G{move}: %s -> %s\n", src, dst)
+
+	err := copyObject(mv.c, src, dst, size, mv.directive, mv.headers)
+	if err == nil {
+		err = confirm(mv.c, dst, size)
+	}
+	if err != nil {
+		mv.left = append(mv.left, fmt.Sprintf("%s:%s (not copied: %s)", src.Bucket, src.Key, err))
+		return err
+	}
+
+	if err := deleteObject(mv.c, src.Bucket, src.Key); err != nil {
+		mv.left = append(mv.left, fmt.Sprintf("%s:%s (copied to %s:%s, but not deleted: %s)", src.Bucket, src.Key, dst.Bucket, dst.Key, err))
+		return err
+	}
+	mv.moved = append(mv.moved, fmt.Sprintf("%s:%s -> %s:%s", src.Bucket, src.Key, dst.Bucket, dst.Key))
+	return nil
+}
+
+// one moves a single object, looking up its size first.
+func (mv *mover) one(src, dst location) error {
+	if src == dst {
+		return fmt.Errorf("cannot move %s:%s onto itself", src.Bucket, src.Key)
+	}
+	h, err := head(mv.c, src.Bucket, src.Key)
+	if err != nil {
+		return fmt.Errorf("%s:%s: %s", src.Bucket, src.Key, err)
+	}
+	size, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	return mv.move(src, dst, size)
+}
+
+// all moves every object under the src prefix to the same relative
+// key under the dst prefix, carrying on past any objects that can't
+// be moved.  The returned error is for the listing itself; use
+// failed() to find out if everything made it.
+func (mv *mover) all(src, dst location) error {
+	if src.Bucket == dst.Bucket && strings.HasPrefix(dst.Key, src.Key) {
+		return fmt.Errorf("cannot move %s:%s into itself (%s:%s)", src.Bucket, src.Key, dst.Bucket, dst.Key)
+	}
+
+	return newListing(src.Bucket, src.Key).eachObject(mv.c, func(o s3.Object) error {
+		from := location{Bucket: src.Bucket, Key: o.Key}
+		to := location{Bucket: dst.Bucket, Key: dst.Key + strings.TrimPrefix(o.Key, src.Key)}
+
+		mv.move(from, to, int64(o.Size))
+		return nil
+	})
+}
+
+func (mv *mover) failed() bool {
+	return len(mv.left) > 0
+}
+
+// report prints out which objects were moved, and which were left
+// in place (and why), for when not everything could be moved.
+func (mv *mover) report() {
+	fmt.Fprintf(os.Stderr, "\n@Y{moved %d file(s):}\n", len(mv.moved))
+	for _, s := range mv.moved {
+		fmt.Fprintf(os.Stderr, "  - %s\n", s)
+	}
+	fmt.Fprintf(os.Stderr, "\n@R{left %d file(s) in place:}\n", len(mv.left))
+	for _, s := range mv.left {
+		fmt.Fprintf(os.Stderr, "  - %s\n", s)
+	}
+}
+
+// confirm checks that a freshly copied object is really there,
+// and is the size we expect it to be.
+func confirm(c *s3.Client, l location, size int64) error {
+	h, err := head(c, l.Bucket, l.Key)
+	if err != nil {
+		return fmt.Errorf("unable to confirm copy: %s", err)
+	}
+	if n, _ := strconv.ParseInt(h.Get("Content-Length"), 10, 64); n != size {
+		return fmt.Errorf("unable to confirm copy: expected %d bytes, found %d", size, n)
+	}
+	return nil
+}
+
+// deleteObject deletes a key from a bucket.  Unlike the client's
+// Delete(), the bucket doesn't have to be the --bucket bucket.
+func deleteObject(c *s3.Client, bucket, key string) error {
+	res, err := request(c, "DELETE", bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 204 && res.StatusCode != 200 {
+		return responseError(res)
+	}
+	res.Body.Close()
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// go-s3's Upload keeps its upload ID (and its list of parts) to
+// itself, which makes it impossible to resume an interrupted upload,
+// or to do much of anything else with one.  A multipart is our own
+// handle on an in-flight multipart upload, for the operations that
+// need more control over the individual parts.
+type multipart struct {
+	c      *s3.Client
+	Bucket string
+	Key    string
+	ID     string
+
+	// if the upload was started with a flexible checksum algorithm,
+	// every part has to be sent with a checksum.
+	Checksum *checksumAlgorithm
+
+	lock  sync.Mutex
+	parts []completedPart
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	checksums
+
+	md5 []byte // of what we sent, if we know.
+}
+
+// startMultipart initiates a new multipart upload to a key in a
+// bucket.  Object metadata (Content-Type, x-amz-meta-*, etc.) has
+// to be given here; it can't be set on the individual parts.  So
+// does the checksum algorithm, if the parts are to have checksums.
+func startMultipart(c *s3.Client, bucket, key string, headers http.Header, checksum *checksumAlgorithm) (*multipart, error) {
+	if checksum != nil {
+		headers = headers.Clone()
+		if headers == nil {
+			headers = http.Header{}
+		}
+		headers.Set("X-Amz-Checksum-Algorithm", checksum.String())
+	}
+
+	res, err := request(c, "POST", bucket, key, url.Values{"uploads": {""}}, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, responseError(res)
+	}
+
+	var r struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := readXML(res, &r); err != nil {
+		return nil, err
+	}
+	debugf("started multipart upload @M{%s} for @Y{%s}:@C{%s}", r.UploadID, bucket, key)
+	return &multipart{c: c, Bucket: bucket, Key: key, ID: r.UploadID, Checksum: checksum}, nil
+}
+
+func (m *multipart) query(n int) url.Values {
+	q := url.Values{"uploadId": {m.ID}}
+	if n > 0 {
+		q.Set("partNumber", strconv.Itoa(n))
+	}
+	return q
+}
+
+// done notes that part n has been uploaded, with the given ETag, MD5
+// and (if the upload has a checksum algorithm) checksum.
+func (m *multipart) done(n int, etag string, sum []byte, checksum string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	p := completedPart{PartNumber: n, ETag: etag, md5: sum}
+	if m.Checksum != nil {
+		p.set(m.Checksum, checksum)
+	}
+	m.parts = append(m.parts, p)
+}
+
+// copyPart fills in part n of the upload with the bytes first
+// through last (inclusive) of an existing object, server-side,
+// via UploadPartCopy.
+func (m *multipart) copyPart(n int, bucket, key string, first, last int64) error {
+	headers := http.Header{}
+	headers.Set("X-Amz-Copy-Source", copySource(bucket, key))
+	headers.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", first, last))
+
+	res, err := request(m.c, "PUT", m.Bucket, m.Key, m.query(n), headers, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return responseError(res)
+	}
+
+	var r struct {
+		ETag string `xml:"ETag"`
+	}
+	if err := readXML(res, &r); err != nil {
+		return err
+	}
+	m.done(n, r.ETag, nil, "")
+	return nil
+}
+
+// uploadPart sends part n of the upload, along with its MD5 (and its
+// checksum, if the upload has a checksum algorithm), so that S3 can
+// check that it got there intact.
+func (m *multipart) uploadPart(n int, data []byte) (string, error) {
+	sum := md5.Sum(data)
+	headers := http.Header{}
+	headers.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	checksum := ""
+	if m.Checksum != nil {
+		checksum = m.Checksum.sum(data)
+		headers.Set(m.Checksum.Header(), checksum)
+	}
+
+	res, err := request(m.c, "PUT", m.Bucket, m.Key, m.query(n), headers, data)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != 200 {
+		return "", responseError(res)
+	}
+	res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+	m.done(n, etag, sum[:], checksum)
+	return etag, nil
+}
+
+// An uploadedPart is a part that S3 already has, per ListParts.
+type uploadedPart struct {
+	PartNumber   int       `xml:"PartNumber"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+	checksums
+}
+
+// errNoSuchUpload means that the upload we were looking for has
+// been completed, or aborted, or was never there to begin with.
+var errNoSuchUpload = fmt.Errorf("no such multipart upload")
+
+// listParts asks S3 which parts of the upload it already has.
+func (m *multipart) listParts() ([]uploadedPart, error) {
+	var parts []uploadedPart
+	marker := ""
+	for {
+		q := m.query(0)
+		if marker != "" {
+			q.Set("part-number-marker", marker)
+		}
+		res, err := request(m.c, "GET", m.Bucket, m.Key, q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == 404 {
+			res.Body.Close()
+			return nil, errNoSuchUpload
+		}
+		if res.StatusCode != 200 {
+			return nil, responseError(res)
+		}
+
+		var r struct {
+			IsTruncated          bool           `xml:"IsTruncated"`
+			NextPartNumberMarker string         `xml:"NextPartNumberMarker"`
+			Parts                []uploadedPart `xml:"Part"`
+		}
+		if err := readXML(res, &r); err != nil {
+			return nil, err
+		}
+		parts = append(parts, r.Parts...)
+		if !r.IsTruncated || r.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = r.NextPartNumberMarker
+	}
+}
+
+// complete stitches the uploaded parts together into the final
+// object.  S3 wants them listed in ascending part number order.
+//
+// If we know the MD5 of every part, we also know what the ETag of
+// the finished object should be, and check that S3 agrees.  The same
+// goes for its (composite) checksum, if it has one.
+func (m *multipart) complete() error {
+	m.lock.Lock()
+	sort.Slice(m.parts, func(i, j int) bool { return m.parts[i].PartNumber < m.parts[j].PartNumber })
+	b, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: m.parts})
+	sums := make([][]byte, 0, len(m.parts))
+	for _, p := range m.parts {
+		if p.md5 == nil {
+			sums = nil
+			break
+		}
+		sums = append(sums, p.md5)
+	}
+	composite := ""
+	if m.Checksum != nil {
+		checksums := make([]string, len(m.parts))
+		for i, p := range m.parts {
+			checksums[i] = p.get(m.Checksum)
+		}
+		composite = m.Checksum.composite(checksums)
+	}
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	res, err := request(m.c, "POST", m.Bucket, m.Key, m.query(0), nil, b)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return responseError(res)
+	}
+	sse := res.Header.Get("X-Amz-Server-Side-Encryption")
+
+	var r struct {
+		ETag string `xml:"ETag"`
+		checksums
+	}
+	if err := readXML(res, &r); err != nil {
+		return err
+	}
+
+	if m.Checksum != nil {
+		if got := r.get(m.Checksum); got == "" || composite == "" {
+			debugf("unable to verify the %s checksum of @Y{%s}:@C{%s}", m.Checksum, m.Bucket, m.Key)
+		} else if got != composite {
+			return fmt.Errorf("%s:%s may not have arrived intact: S3 says its %s checksum is %s, but it should be %s", m.Bucket, m.Key, m.Checksum, got, composite)
+		} else {
+			debugf("verified the %s checksum of @Y{%s}:@C{%s} (@W{%s})", m.Checksum, m.Bucket, m.Key, got)
+		}
+	}
+
+	if sums == nil || r.ETag == "" || sse == "aws:kms" {
+		debugf("unable to verify the ETag of @Y{%s}:@C{%s}", m.Bucket, m.Key)
+		return nil
+	}
+	if want, got := multipartETag(sums), hexETag(r.ETag); got != want {
+		return fmt.Errorf("%s:%s may not have arrived intact: S3 says its ETag is %s, but it should be %s", m.Bucket, m.Key, got, want)
+	}
+	debugf("verified the ETag of @Y{%s}:@C{%s} (@W{%s})", m.Bucket, m.Key, hexETag(r.ETag))
+	return nil
+}
+
+// abort throws away the upload, and any parts already sent for it,
+// so that they don't linger (and get billed for) in the bucket.
+func (m *multipart) abort() error {
+	debugf("aborting multipart upload @M{%s} for @Y{%s}:@C{%s}", m.ID, m.Bucket, m.Key)
+	res, err := request(m.c, "DELETE", m.Bucket, m.Key, m.query(0), nil, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 204 && res.StatusCode != 200 {
+		return responseError(res)
+	}
+	res.Body.Close()
+	return nil
+}
+
+// readXML decodes an XML response body into v, and closes it.
+//
+// Some S3 operations (CopyObject, UploadPartCopy and
+// CompleteMultipartUpload among them) can fail *after* sending
+// back a 200 OK, in which case the body is an <Error> document
+// rather than the result we asked for; that gets turned into an
+// error here.
+func readXML(res *http.Response, v interface{}) error {
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(b, &root); err != nil {
+		if len(bytes.TrimSpace(b)) == 0 {
+			return nil
+		}
+		return err
+	}
+	if root.XMLName.Local == "Error" {
+		return s3.ResponseErrorFrom(b)
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// copySource formats the X-Amz-Copy-Source header value for a key
+// in a bucket.  The key has to be URL-encoded, same as in a path.
+func copySource(bucket, key string) string {
+	return uriencode("/"+bucket+"/"+strings.TrimPrefix(key, "/"), false)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// formats lists the --output formats we know how to render,
+// in the order we want to show them in help / error messages.
+var formats = []string{"table", "json", "jsonl", "yaml", "csv", "tsv"}
+
+func validFormat(f string) bool {
+	for _, ok := range formats {
+		if f == ok {
+			return true
+		}
+	}
+	return false
+}
+
+// A column describes one field of a listing.  Field is the
+// stable, machine-readable name used by the json / yaml / csv
+// renderers; Header and Color are only used for tables.
+type column struct {
+	Field  string
+	Header string
+	Color  string
+}
+
+// Rows given to a renderer may be buffered until the next call to
+// Flush() (or Close()), which lets tables size their columns to fit.
+// Streaming callers should Flush() after each batch of rows.
+type renderer interface {
+	Row(values ...interface{})
+	Flush()
+	Close() error
+}
+
+// render returns a renderer for the --output format the user asked
+// for, writing to standard output.  Callers pass one value per column
+// to Row(), in column order; nil values are rendered as empty / null.
+func render(cols ...column) renderer {
+	return renderTo(os.Stdout, cols...)
+}
+
+func renderTo(out io.Writer, cols ...column) renderer {
+	switch opts.Output {
+	case "json":
+		return &jsonRenderer{out: out, cols: cols}
+	case "jsonl":
+		return &jsonRenderer{out: out, cols: cols, lines: true}
+	case "yaml":
+		return &yamlRenderer{out: out, cols: cols}
+	case "csv":
+		return newCSVRenderer(out, ',', cols)
+	case "tsv":
+		return newCSVRenderer(out, '\t', cols)
+	default:
+		return &tableRenderer{out: out, cols: cols}
+	}
+}
+
+// plain converts a value into something the machine-readable
+// renderers can represent faithfully: sizes become integer byte
+// counts, and timestamps become RFC3339 strings.
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case s3.Bytes:
+		return int64(v)
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+func plainString(v interface{}) string {
+	switch v := plain(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+type tableRenderer struct {
+	out    io.Writer
+	cols   []column
+	rows   [][]string
+	widths []int
+}
+
+func (r *tableRenderer) Row(values ...interface{}) {
+	row := make([]string, len(r.cols))
+	for i := range r.cols {
+		if i < len(values) && values[i] != nil {
+			row[i] = fmt.Sprintf("%v", values[i])
+		}
+	}
+	r.rows = append(r.rows, row)
+}
+
+// Flush prints the buffered rows.  The header is printed on the first
+// flush; column widths only ever grow, so that later batches stay
+// aligned with earlier ones as best they can.
+func (r *tableRenderer) Flush() {
+	first := r.widths == nil
+	if first {
+		r.widths = make([]int, len(r.cols))
+		for i, col := range r.cols {
+			r.widths[i] = len(col.Header)
+		}
+	}
+	for i := range r.cols {
+		for _, row := range r.rows {
+			r.widths[i] = max(r.widths[i], len(row[i]))
+		}
+	}
+
+	if first {
+		header := make([]string, len(r.cols))
+		for i, col := range r.cols {
+			header[i] = fmt.Sprintf("%-*s", r.widths[i], col.Header)
+		}
+		fmt.Fprintf(r.out, "%s\n", strings.Join(header, "  "))
+	}
+
+	for _, row := range r.rows {
+		line := make([]string, len(r.cols))
+		for i, col := range r.cols {
+			if col.Color != "" {
+				line[i] = fmt.Sprintf("@"+col.Color+"{%-*s}", r.widths[i], row[i])
+			} else {
+				line[i] = fmt.Sprintf("%-*s", r.widths[i], row[i])
+			}
+		}
+		fmt.Fprintf(r.out, "%s\n", strings.Join(line, "  "))
+	}
+	r.rows = nil
+}
+
+func (r *tableRenderer) Close() error {
+	r.Flush()
+	return nil
+}
+
+type jsonRenderer struct {
+	out   io.Writer
+	cols  []column
+	lines bool
+	n     int
+	err   error
+}
+
+func (r *jsonRenderer) Row(values ...interface{}) {
+	if r.err != nil {
+		return
+	}
+
+	fields := make([]string, len(r.cols))
+	for i, col := range r.cols {
+		var v interface{}
+		if i < len(values) {
+			v = plain(values[i])
+		}
+		k, _ := json.Marshal(col.Field)
+		b, err := json.Marshal(v)
+		if err != nil {
+			r.err = err
+			return
+		}
+		fields[i] = string(k) + ":" + string(b)
+	}
+
+	obj := "{" + strings.Join(fields, ",") + "}"
+	switch {
+	case r.lines:
+		fmt.Fprintf(r.out, "%s\n", obj)
+	case r.n == 0:
+		fmt.Fprintf(r.out, "[\n  %s", obj)
+	default:
+		fmt.Fprintf(r.out, ",\n  %s", obj)
+	}
+	r.n++
+}
+
+func (r *jsonRenderer) Flush() {}
+
+func (r *jsonRenderer) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.lines {
+		return nil
+	}
+	if r.n == 0 {
+		fmt.Fprintf(r.out, "[]\n")
+	} else {
+		fmt.Fprintf(r.out, "\n]\n")
+	}
+	return nil
+}
+
+type yamlRenderer struct {
+	out  io.Writer
+	cols []column
+	n    int
+}
+
+func (r *yamlRenderer) Row(values ...interface{}) {
+	for i, col := range r.cols {
+		var v interface{}
+		if i < len(values) {
+			v = plain(values[i])
+		}
+
+		lead := "  "
+		if i == 0 {
+			lead = "- "
+		}
+		fmt.Fprintf(r.out, "%s%s: %s\n", lead, col.Field, yamlScalar(v))
+	}
+	r.n++
+}
+
+func (r *yamlRenderer) Flush() {}
+
+func (r *yamlRenderer) Close() error {
+	if r.n == 0 {
+		fmt.Fprintf(r.out, "[]\n")
+	}
+	return nil
+}
+
+// yamlScalar renders a single value as a YAML scalar.  Strings are
+// always double-quoted (JSON string syntax is valid YAML), so that
+// keys like "yes", "null" or "007" survive a round-trip.
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		b, _ := json.Marshal(v)
+		return string(b)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+type csvRenderer struct {
+	w    *csv.Writer
+	cols []column
+}
+
+func newCSVRenderer(out io.Writer, comma rune, cols []column) *csvRenderer {
+	r := &csvRenderer{w: csv.NewWriter(out), cols: cols}
+	r.w.Comma = comma
+
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Field
+	}
+	r.w.Write(header)
+	return r
+}
+
+func (r *csvRenderer) Row(values ...interface{}) {
+	row := make([]string, len(r.cols))
+	for i := range r.cols {
+		if i < len(values) {
+			row[i] = plainString(values[i])
+		}
+	}
+	r.w.Write(row)
+}
+
+func (r *csvRenderer) Flush() {
+	r.w.Flush()
+}
+
+func (r *csvRenderer) Close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jhunt/go-s3"
+)
+
+// renderRows renders rows of an `s3 ls` listing in the given --output
+// format, and returns what would have gone to standard output.
+func renderRows(format string, rows ...[]interface{}) (string, error) {
+	was := opts.Output
+	opts.Output = format
+	defer func() { opts.Output = was }()
+
+	var out bytes.Buffer
+	r := renderTo(&out, lsColumns...)
+	for _, row := range rows {
+		r.Row(row...)
+	}
+	r.Flush()
+	err := r.Close()
+	return out.String(), err
+}
+
+var (
+	lsObject = []interface{}{
+		"photos/a, \"b\".jpg",
+		"object",
+		time.Date(2026, 1, 2, 4, 4, 5, 0, time.FixedZone("CET", 3600)),
+		"jhunt",
+		`"9b2cf535f27731c974343645a3985328"`,
+		s3.Bytes(1024),
+	}
+	lsPrefix = []interface{}{"photos/2026/", "prefix", nil, nil, nil, nil}
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format string
+		rows   [][]interface{}
+		out    string
+	}{
+		{format: "json", out: "[]\n"},
+		{format: "jsonl", out: ""},
+		{format: "yaml", out: "[]\n"},
+		{format: "csv", out: "key,type,last_modified,owner,etag,size\n"},
+		{format: "tsv", out: "key\ttype\tlast_modified\towner\tetag\tsize\n"},
+		{
+			format: "json",
+			rows:   [][]interface{}{lsPrefix},
+			out: "[\n" +
+				`  {"key":"photos/2026/","type":"prefix","last_modified":null,"owner":null,"etag":null,"size":null}` + "\n" +
+				"]\n",
+		},
+		{
+			format: "json",
+			rows:   [][]interface{}{lsPrefix, lsObject},
+			out: "[\n" +
+				`  {"key":"photos/2026/","type":"prefix","last_modified":null,"owner":null,"etag":null,"size":null},` + "\n" +
+				`  {"key":"photos/a, \"b\".jpg","type":"object","last_modified":"2026-01-02T03:04:05Z","owner":"jhunt","etag":"\"9b2cf535f27731c974343645a3985328\"","size":1024}` + "\n" +
+				"]\n",
+		},
+		{
+			format: "jsonl",
+			rows:   [][]interface{}{lsPrefix, lsObject},
+			out: `{"key":"photos/2026/","type":"prefix","last_modified":null,"owner":null,"etag":null,"size":null}` + "\n" +
+				`{"key":"photos/a, \"b\".jpg","type":"object","last_modified":"2026-01-02T03:04:05Z","owner":"jhunt","etag":"\"9b2cf535f27731c974343645a3985328\"","size":1024}` + "\n",
+		},
+		{
+			format: "yaml",
+			rows:   [][]interface{}{lsPrefix, lsObject},
+			out: "- key: \"photos/2026/\"\n" +
+				"  type: \"prefix\"\n" +
+				"  last_modified: null\n" +
+				"  owner: null\n" +
+				"  etag: null\n" +
+				"  size: null\n" +
+				"- key: \"photos/a, \\\"b\\\".jpg\"\n" +
+				"  type: \"object\"\n" +
+				"  last_modified: \"2026-01-02T03:04:05Z\"\n" +
+				"  owner: \"jhunt\"\n" +
+				"  etag: \"\\\"9b2cf535f27731c974343645a3985328\\\"\"\n" +
+				"  size: 1024\n",
+		},
+		{
+			format: "csv",
+			rows:   [][]interface{}{lsPrefix, lsObject},
+			out: "key,type,last_modified,owner,etag,size\n" +
+				"photos/2026/,prefix,,,,\n" +
+				`"photos/a, ""b"".jpg",object,2026-01-02T03:04:05Z,jhunt,"""9b2cf535f27731c974343645a3985328""",1024` + "\n",
+		},
+		{
+			format: "tsv",
+			rows:   [][]interface{}{lsPrefix, lsObject, {"tab\there", "object", nil, "line\nbreak", nil, s3.Bytes(0)}},
+			out: "key\ttype\tlast_modified\towner\tetag\tsize\n" +
+				"photos/2026/\tprefix\t\t\t\t\n" +
+				"\"photos/a, \"\"b\"\".jpg\"\tobject\t2026-01-02T03:04:05Z\tjhunt\t\"\"\"9b2cf535f27731c974343645a3985328\"\"\"\t1024\n" +
+				"\"tab\there\"\tobject\t\t\"line\nbreak\"\t\t0\n",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := renderRows(test.format, test.rows...)
+		if err != nil {
+			t.Errorf("%s (%d rows): %s", test.format, len(test.rows), err)
+			continue
+		}
+		if got != test.out {
+			t.Errorf("%s (%d rows): wrong output\n got: %q\nwant: %q", test.format, len(test.rows), got, test.out)
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	tests := []struct {
+		in  interface{}
+		out string
+	}{
+		{nil, `null`},
+		{"", `""`},
+		{"yes", `"yes"`},
+		{"null", `"null"`},
+		{"007", `"007"`},
+		{"- a: b # c", `"- a: b # c"`},
+		{"say \"hi\"\nthen leave", `"say \"hi\"\nthen leave"`},
+		{int64(1024), `1024`},
+		{true, `true`},
+	}
+	for _, test := range tests {
+		if got := yamlScalar(test.in); got != test.out {
+			t.Errorf("yamlScalar(%#v) = %s, wanted %s", test.in, got, test.out)
+		}
+	}
+}
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		in  interface{}
+		out interface{}
+		str string
+	}{
+		{s3.Bytes(0), int64(0), "0"},
+		{s3.Bytes(1024), int64(1024), "1024"},
+		{s3.Bytes(5 << 40), int64(5 << 40), "5497558138880"},
+		{time.Time{}, nil, ""},
+		{time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC), "2026-01-02T03:04:05Z", "2026-01-02T03:04:05Z"},
+		{time.Date(2026, 1, 1, 22, 4, 5, 0, time.FixedZone("EST", -5*3600)), "2026-01-02T03:04:05Z", "2026-01-02T03:04:05Z"},
+		{nil, nil, ""},
+		{"key", "key", "key"},
+	}
+	for _, test := range tests {
+		if got := plain(test.in); got != test.out {
+			t.Errorf("plain(%#v) = %#v, wanted %#v", test.in, got, test.out)
+		}
+		if got := plainString(test.in); got != test.str {
+			t.Errorf("plainString(%#v) = %q, wanted %q", test.in, got, test.str)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// A postPolicy describes what a browser is allowed to upload via
+// an HTML form POST, straight to a bucket: where, how big, and what
+// type of file.  See "Browser-Based Uploads Using POST" in the S3
+// docs for the gory details.
+type postPolicy struct {
+	Bucket      string
+	KeyPrefix   string
+	MaxSize     int64
+	ContentType string
+	Expires     time.Duration
+}
+
+// A postForm is a signed postPolicy: the URL to POST to, the form
+// fields to send along with the file, and the fields that whoever is
+// uploading has to fill in for themselves, with what each of them has
+// to start with.
+type postForm struct {
+	URL      string
+	Fields   [][2]string
+	Required [][2]string
+}
+
+// sign builds and signs the policy document, returning everything
+// an HTML form needs in order to upload a file under it.
+func (p postPolicy) sign(c *s3.Client, now time.Time) (postForm, error) {
+	cr := currentCredentials()
+	yyyymmdd := now.Format("20060102")
+	credential := fmt.Sprintf("%s/%s/%s/s3/aws4_request", cr.AccessKeyID, yyyymmdd, c.Region)
+	date := now.Format("20060102T150405Z")
+
+	conditions := []interface{}{
+		map[string]string{"bucket": p.Bucket},
+		[]interface{}{"starts-with", "$key", p.KeyPrefix},
+	}
+	if p.MaxSize > 0 {
+		conditions = append(conditions, []interface{}{"content-length-range", 0, p.MaxSize})
+	}
+
+	// "image/*" means any image, which only the uploader knows the
+	// exact type of; anything else has to match exactly.
+	var required [][2]string
+	if strings.HasSuffix(p.ContentType, "*") {
+		prefix := strings.TrimSuffix(p.ContentType, "*")
+		conditions = append(conditions, []interface{}{"starts-with", "$Content-Type", prefix})
+		required = append(required, [2]string{"Content-Type", prefix})
+	} else if p.ContentType != "" {
+		conditions = append(conditions, map[string]string{"Content-Type": p.ContentType})
+	}
+
+	// the signing fields have to be in the form, *and* in the policy.
+	signing := [][2]string{
+		{"x-amz-algorithm", "AWS4-HMAC-SHA256"},
+		{"x-amz-credential", credential},
+		{"x-amz-date", date},
+	}
+	if cr.Token != "" {
+		signing = append(signing, [2]string{"x-amz-security-token", cr.Token})
+	}
+	for _, f := range signing {
+		conditions = append(conditions, map[string]string{f[0]: f[1]})
+	}
+
+	fields := [][2]string{
+		{"key", p.KeyPrefix + "${filename}"},
+	}
+	if p.ContentType != "" && !strings.HasSuffix(p.ContentType, "*") {
+		fields = append(fields, [2]string{"Content-Type", p.ContentType})
+	}
+	fields = append(fields, signing...)
+
+	b, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(p.Expires).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return postForm{}, err
+	}
+	policy := base64.StdEncoding.EncodeToString(b)
+
+	fields = append(fields,
+		[2]string{"policy", policy},
+		[2]string{"x-amz-signature", signature(cr.SecretAccessKey, yyyymmdd, c.Region, "s3", policy)},
+	)
+	return postForm{
+		URL:      endpoint(c, p.Bucket, "", nil).String(),
+		Fields:   fields,
+		Required: required,
+	}, nil
+}
+
+// writeJSON prints the form as a JSON object, with the URL to POST
+// to, the fields (in order) to send with the file, and the names of
+// any fields that the uploader has to add (before the file).
+func (f postForm) writeJSON(out io.Writer) error {
+	fields := make([]string, len(f.Fields))
+	for i, kv := range f.Fields {
+		k, _ := json.Marshal(kv[0])
+		v, _ := json.Marshal(kv[1])
+		fields[i] = fmt.Sprintf("    %s: %s", k, v)
+	}
+	required := make([]string, len(f.Required))
+	for i, kv := range f.Required {
+		k, _ := json.Marshal(kv[0])
+		required[i] = string(k)
+	}
+	u, _ := json.Marshal(f.URL)
+	_, err := fmt.Fprintf(out, "{\n  \"url\": %s,\n  \"fields\": {\n%s\n  },\n  \"required\": [%s]\n}\n", u, strings.Join(fields, ",\n"), strings.Join(required, ", "))
+	return err
+}
+
+// writeHTML prints a ready-to-use HTML upload form.  The file input
+// has to come last; S3 ignores any fields after it.
+func (f postForm) writeHTML(out io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<form action=\"%s\" method=\"post\" enctype=\"multipart/form-data\">\n", html.EscapeString(f.URL))
+	for _, kv := range f.Fields {
+		fmt.Fprintf(&b, "  <input type=\"hidden\" name=\"%s\" value=\"%s\">\n", html.EscapeString(kv[0]), html.EscapeString(kv[1]))
+	}
+	onchange := ""
+	for _, kv := range f.Required {
+		fmt.Fprintf(&b, "  <input type=\"text\" name=\"%s\" value=\"%s\" required>\n", html.EscapeString(kv[0]), html.EscapeString(kv[1]))
+		if kv[0] == "Content-Type" {
+			// fill it in from whatever file gets picked.
+			onchange = " onchange=\"this.form.elements['Content-Type'].value = this.files[0].type\""
+		}
+	}
+	fmt.Fprintf(&b, "  <input type=\"file\" name=\"file\"%s>\n", onchange)
+	b.WriteString("  <input type=\"submit\" value=\"Upload\">\n")
+	b.WriteString("</form>\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
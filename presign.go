@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// SigV4 presigned URLs (and POST policies) can't be valid for more
+// than a week.
+const maxPresign = 7 * 24 * time.Hour
+
+// presign builds a query-string authenticated URL for a key in a
+// bucket, which anyone can use to make the given request (and only
+// that request) until it expires.  Extra query parameters, like the
+// response-* header overrides, are covered by the signature.
+//
+// Only the Host header is signed, and the payload is left unsigned,
+// since we have no idea what (if anything) will be sent with it.
+func presign(c *s3.Client, method, bucket, key string, query url.Values, expires time.Duration, now time.Time) string {
+	cr := currentCredentials()
+	yyyymmdd := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", yyyymmdd, c.Region)
+
+	q := url.Values{}
+	for k, vv := range query {
+		q[k] = vv
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", cr.AccessKeyID+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if cr.Token != "" {
+		q.Set("X-Amz-Security-Token", cr.Token)
+	}
+
+	u := endpoint(c, bucket, key, q)
+	canon := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	sig := signature(cr.SecretAccessKey, yyyymmdd, c.Region, "s3", strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256hex([]byte(canon)),
+	}, "\n"))
+
+	u.RawQuery += "&X-Amz-Signature=" + sig
+	return u.String()
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// Big uploads and downloads can take a long time, and without some
+// feedback, there's no telling a hung transfer from a slow one.
+// With --progress, `put` and `get` show a progress bar on standard
+// error (if it's a terminal; otherwise, a status line every so
+// often) with how much has been transferred, how fast, how much
+// longer it should take, and how many parts are in flight.
+//
+// With --progress=json, they instead write progress events to
+// standard error, one JSON object per line, for wrapper scripts and
+// GUIs: a "start" event when each transfer starts, "progress" events
+// every second while it's under way, and a "done" event at the end.
+//
+// Only one transfer is metered at a time, so it lives in a package
+// variable, where the i/o threads (and request(), for the bodies of
+// uploaded parts) can find it.  Recursive puts go one file at a time,
+// and each gets its own progress bar, but recursive gets download
+// several files at once, and so are metered as a whole.
+
+var (
+	// progressFormat is "text" or "json", or "" for no progress.
+	progressFormat string
+
+	progressLock sync.Mutex
+	meter        *progress
+)
+
+const (
+	progressBarWidth = 30
+
+	// throughput (and so the ETA) is averaged over this long.
+	progressWindow = 5 * time.Second
+)
+
+type progress struct {
+	op    string // "upload" or "download"
+	what  string // bucket:key
+	total int64  // or -1, if we don't know how big it is
+	done  int64
+	from  int64 // what was already done, before we started
+	parts int   // in flight
+	multi bool
+
+	started time.Time
+	samples []progressSample
+
+	tty   bool
+	drawn bool // is the bar on the screen?
+	quit  chan struct{}
+	wg    sync.WaitGroup
+}
+
+type progressSample struct {
+	at   time.Time
+	done int64
+}
+
+// progressArgs picks --progress=FORMAT out of the command-line
+// arguments (go-cli has no --flag=VALUE syntax), leaving a plain
+// --progress in its place, and returns the format.
+func progressArgs(args []string) ([]string, string, error) {
+	format := ""
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			out = append(out, args[i:]...)
+			break
+		}
+		if strings.HasPrefix(arg, "--progress=") {
+			format = strings.TrimPrefix(arg, "--progress=")
+			if format != "text" && format != "json" {
+				return nil, "", fmt.Errorf("unrecognized --progress format '%s' (must be either text or json)", format)
+			}
+			arg = "--progress"
+		}
+		out = append(out, arg)
+	}
+	return out, format, nil
+}
+
+// noProgress is what startProgress returns when it has nothing to
+// meter.
+func noProgress(error) {}
+
+// startProgress starts metering a transfer of `total` bytes (or -1,
+// if we don't know), `done` of which were already transferred (by
+// an earlier, interrupted attempt), and returns a function to call
+// when it's over, with whether or not it succeeded.  Unless
+// --progress was given, this does nothing.  Transfers started while
+// another is being metered just count towards that one.
+func startProgress(op, bucket, key string, total, done int64) func(error) {
+	if progressFormat == "" {
+		return noProgress
+	}
+
+	progressLock.Lock()
+	if meter != nil {
+		progressLock.Unlock()
+		return noProgress
+	}
+
+	now := time.Now()
+	p := &progress{
+		op:      op,
+		what:    bucket + ":" + key,
+		total:   total,
+		done:    done,
+		from:    done,
+		started: now,
+		samples: []progressSample{{at: now, done: done}},
+		tty:     progressFormat == "text" && fmt.CanColorize(os.Stderr),
+		quit:    make(chan struct{}),
+	}
+
+	every := 10 * time.Second
+	if p.tty {
+		every = 250 * time.Millisecond
+	} else if progressFormat == "json" {
+		every = time.Second
+	}
+
+	meter = p
+	if progressFormat == "json" {
+		p.event("start", nil)
+	}
+	progressLock.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.quit:
+				return
+			case now := <-t.C:
+				progressLock.Lock()
+				p.sample(now)
+				if progressFormat == "json" {
+					p.event("progress", nil)
+				} else {
+					p.draw(nil, false)
+				}
+				progressLock.Unlock()
+			}
+		}
+	}()
+	return p.stop
+}
+
+// stop finishes off the transfer, with its final tally, and whether
+// or not it succeeded.
+func (p *progress) stop(err error) {
+	close(p.quit)
+	p.wg.Wait()
+
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	meter = nil
+	p.sample(time.Now())
+	if progressFormat == "json" {
+		p.event("done", err)
+	} else {
+		p.draw(err, true)
+	}
+}
+
+// progressReader counts everything read through r towards the
+// transfer being metered, if there is one.
+func progressReader(r io.Reader) io.Reader {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	if meter == nil {
+		return r
+	}
+	return &progressCounter{r: r, p: meter}
+}
+
+type progressCounter struct {
+	r io.Reader
+	p *progress
+}
+
+func (c *progressCounter) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	progressLock.Lock()
+	c.p.done += int64(n)
+	progressLock.Unlock()
+	return n, err
+}
+
+// partsInFlight notes that a part has started (+1) or stopped (-1)
+// being sent or fetched, for the transfer being metered.
+func partsInFlight(delta int) {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	if meter != nil {
+		meter.parts += delta
+		meter.multi = true
+	}
+}
+
+// aboveProgress runs fn, which writes to standard error, after
+// getting the progress bar out of its way.  The bar is redrawn (below
+// whatever fn wrote) the next time around.
+func aboveProgress(fn func()) {
+	progressLock.Lock()
+	defer progressLock.Unlock()
+	if meter != nil && meter.drawn {
+		fmt.Fprintf(os.Stderr, "\r\033[K")
+		meter.drawn = false
+	}
+	fn()
+}
+
+// sample remembers how far along we were at a given time, for
+// working out the throughput.  Callers must hold progressLock.
+func (p *progress) sample(now time.Time) {
+	p.samples = append(p.samples, progressSample{at: now, done: p.done})
+	for len(p.samples) > 2 && now.Sub(p.samples[1].at) >= progressWindow {
+		p.samples = p.samples[1:]
+	}
+}
+
+// rate is the recent throughput, in bytes per second.
+func (p *progress) rate() float64 {
+	first, last := p.samples[0], p.samples[len(p.samples)-1]
+	if secs := last.at.Sub(first.at).Seconds(); secs > 0 {
+		return float64(last.done-first.done) / secs
+	}
+	return 0
+}
+
+// eta is how much longer the transfer ought to take, at the current
+// rate, or -1 if there's no telling.
+func (p *progress) eta() time.Duration {
+	rate := p.rate()
+	if p.total < 0 || rate <= 0 {
+		return -1
+	}
+	return time.Duration(float64(p.total-p.done)/rate) * time.Second
+}
+
+func (p *progress) percent() int {
+	if p.total <= 0 {
+		return 100
+	}
+	pct := int(p.done * 100 / p.total)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// overall is the average throughput of the whole transfer (not
+// counting what was done before we started), in bytes per second.
+func (p *progress) overall() float64 {
+	if secs := time.Since(p.started).Seconds(); secs > 0 {
+		return float64(p.done-p.from) / secs
+	}
+	return 0
+}
+
+// draw writes out the progress bar (or, if standard error isn't a
+// terminal, a status line).  The final draw says how it went, overall.
+// Callers must hold progressLock.
+func (p *progress) draw(err error, final bool) {
+	var b strings.Builder
+	add := func(f string, args ...interface{}) {
+		b.WriteString(fmt.Sprintf(f, args...))
+	}
+
+	if p.tty {
+		add("\r\033[K")
+	}
+	add("@C{%s}  ", p.what)
+
+	elapsed := time.Since(p.started).Round(100 * time.Millisecond)
+	if final && err != nil {
+		add("@R{failed} after %s, with %s transferred\n", elapsed, humanBytes(p.done))
+	} else if final {
+		add("@G{%s} in %s (@W{%s/s})\n", humanBytes(p.done), elapsed, humanBytes(int64(p.overall())))
+	} else {
+		if p.total >= 0 {
+			pct := p.percent()
+			full := pct * progressBarWidth / 100
+			add("[@G{%s}%s] %3d%%  %s / %s", strings.Repeat("#", full), strings.Repeat("-", progressBarWidth-full), pct, humanBytes(p.done), humanBytes(p.total))
+		} else {
+			add("%s", humanBytes(p.done))
+		}
+		add("  @W{%s/s}", humanBytes(int64(p.rate())))
+		if eta := p.eta(); eta >= 0 {
+			add("  ETA %s", eta)
+		}
+		if p.multi {
+			add("  @Y{%d} part(s) in flight", p.parts)
+		}
+		if !p.tty {
+			add("\n")
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%s", b.String())
+	p.drawn = p.tty && !final
+}
+
+// event writes out a JSON progress event.  Callers must hold
+// progressLock.
+func (p *progress) event(what string, err error) {
+	e := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"event": what,
+		"op":    p.op,
+		"key":   p.what,
+		"bytes": p.done,
+	}
+	if p.total >= 0 {
+		e["total_bytes"] = p.total
+		e["percent"] = p.percent()
+	}
+
+	switch what {
+	case "progress":
+		e["bytes_per_second"] = int64(p.rate())
+		if eta := p.eta(); eta >= 0 {
+			e["eta_seconds"] = int64(eta.Seconds())
+		}
+		if p.multi {
+			e["parts_in_flight"] = p.parts
+		}
+
+	case "done":
+		e["seconds"] = time.Since(p.started).Seconds()
+		e["bytes_per_second"] = int64(p.overall())
+		e["ok"] = err == nil
+		if err != nil {
+			e["error"] = err.Error()
+		}
+	}
+
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err == nil {
+		os.Stderr.WriteString(b.String())
+	}
+}
+
+// humanBytes formats a size (or a rate) to a tenth of a KiB, MiB,
+// etc., which is more precise than s3.Bytes, for sizes that keep
+// changing.
+func humanBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	for _, unit := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		f /= 1024
+		if f < 1024 || unit == "TiB" {
+			return fmt.Sprintf("%.1f%s", f, unit)
+		}
+	}
+	return "" // not reached
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// A single HTTP stream is rarely the fastest way to get a big object
+// out of S3.  Instead, big downloads are split into byte ranges, or
+// "parts" (the same way big uploads are split into the parts of a
+// multipart upload), which are fetched `threads` at a time.
+//
+// Every range is requested with If-Match on the ETag of the object,
+// so that if the object is replaced mid-download, we find out about
+// it, rather than stitching together pieces of two different files.
+
+// downloadPartSize is how big each ranged part is, by default.
+const downloadPartSize = 8 * (1 << 20)
+
+// errObjectChanged means that the object being downloaded no longer
+// has the ETag that it had when we started.
+var errObjectChanged = fmt.Errorf("object has changed")
+
+// errNoRanges means that the server ignored the Range header, and sent
+// back the whole object; not every S3 work-alike can do ranges.  When
+// nothing has been fetched yet, download() falls back to fetching the
+// object in one go.
+var errNoRanges = fmt.Errorf("the server does not support ranged downloads")
+
+// downloadOptions says how download() should go about it.
+type downloadOptions struct {
+	Range    string // just these bytes (see byterange)
+	Continue bool   // pick up an interrupted download
+	Threads  int    // how many parts to fetch at once
+	PartSize int64  // how big those parts are
+}
+
+// fetchRange reads len(buf) bytes, starting at offset, of the version
+// of an object with the given ETag, into buf.
+func fetchRange(c *s3.Client, key, etag string, offset int64, buf []byte) error {
+	headers := http.Header{}
+	headers.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(buf))-1))
+	headers.Set("If-Match", etag)
+
+	res, err := request(c, "GET", c.Bucket, key, nil, headers, nil)
+	if err != nil {
+		return err
+	}
+	switch res.StatusCode {
+	case 206:
+	case 200:
+		res.Body.Close()
+		return errNoRanges
+	case 412:
+		res.Body.Close()
+		return errObjectChanged
+	default:
+		return responseError(res)
+	}
+	defer res.Body.Close()
+
+	// not every S3 work-alike honors If-Match, so we check too.
+	if strings.Trim(res.Header.Get("ETag"), `"`) != strings.Trim(etag, `"`) {
+		return errObjectChanged
+	}
+	_, err = io.ReadFull(progressReader(res.Body), buf)
+	return err
+}
+
+// fetchParts downloads the parts of an object (of the given size,
+// with the given ETag) handed out by `next`, `threads` at a time,
+// until it runs out (returning a part number of 0), or until
+// something goes wrong.  As with sendParts, each part is read into
+// one of a handful of part-sized buffers, which are reused once
+// `got` has been called with them.  `got` is also called for parts
+// that couldn't be fetched, with the error.
+func fetchParts(c *s3.Client, key, etag string, size, partSize int64, threads int, next func() int, got func(int, []byte, error) error) (int64, error) {
+	type job struct {
+		n    int
+		data []byte
+	}
+
+	if threads < 1 {
+		threads = 1
+	}
+
+	var (
+		jobs  = make(chan job)
+		free  = make(chan []byte, threads+1)
+		bufs  int
+		wg    sync.WaitGroup
+		lock  sync.Mutex
+		total int64
+		err   error
+	)
+	fail := func(e error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err == nil {
+			err = e
+		}
+	}
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return err != nil
+	}
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if !failed() {
+					offset := int64(j.n-1) * partSize
+					debugf("  - downloading part @W{%d} (%s) of @C{%s}", j.n, s3.Bytes(int64(len(j.data))), key)
+					partsInFlight(1)
+					e := fetchRange(c, key, etag, offset, j.data)
+					partsInFlight(-1)
+					if e != nil && e != errObjectChanged && e != errNoRanges {
+						e = fmt.Errorf("part %d: %s", j.n, e)
+					}
+					if e = got(j.n, j.data, e); e != nil {
+						fail(e)
+					} else {
+						lock.Lock()
+						total += int64(len(j.data))
+						lock.Unlock()
+					}
+				}
+				free <- j.data[:cap(j.data)]
+			}
+		}()
+	}
+
+	for !failed() {
+		n := next()
+		if n == 0 {
+			break
+		}
+
+		var buf []byte
+		select {
+		case buf = <-free:
+		default:
+			if bufs <= threads {
+				buf = make([]byte, partSize)
+				bufs++
+			} else {
+				buf = <-free
+			}
+		}
+		jobs <- job{n: n, data: buf[:min64(partSize, size-int64(n-1)*partSize)]}
+	}
+	close(jobs)
+	wg.Wait()
+	return total, err
+}
+
+// downloadParts downloads an object into a local file, in parallel
+// ranged parts, which are written straight into place.  The journal
+// says which object (and version) it is, how big, and, if we are
+// continuing an interrupted download, which parts we already have.
+func downloadParts(c *s3.Client, key, to string, j *downloadJournal, threads int, fresh bool) (int64, error) {
+	flags := os.O_WRONLY
+	if fresh {
+		flags |= os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(to, flags, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if fresh {
+		// set aside room for the whole file up front, so that parts
+		// can be written wherever they go, as they come in.
+		if err := f.Truncate(j.Size); err != nil {
+			return 0, err
+		}
+		if err := j.save(); err != nil {
+			warnf("unable to journal download of %s: %s", to, err)
+		}
+	} else if info, err := f.Stat(); err != nil || info.Size() != j.Size {
+		return 0, fmt.Errorf("%s is not the size that it should be, so it can't be safely continued; download it again without --continue", to)
+	}
+
+	part := 0
+	parts := int((j.Size + j.PartSize - 1) / j.PartSize)
+	debugf("downloading @Y{%s}:@C{%s} to @C{%s} in @W{%d} part(s), @W{%d} at a time", c.Bucket, key, to, parts, threads)
+	total, err := fetchParts(c, key, j.ETag, j.Size, j.PartSize, threads, func() int {
+		for part < parts {
+			part++
+			if !j.has(part) {
+				return part
+			}
+		}
+		return 0
+	}, func(n int, data []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(data, int64(n-1)*j.PartSize); err != nil {
+			return err
+		}
+		return j.record(n)
+	})
+
+	if err == errNoRanges {
+		if fresh && total == 0 {
+			j.remove()
+			return 0, err
+		}
+		return total, fmt.Errorf("%s; download %s again without --continue", err, to)
+	}
+	if err == errObjectChanged {
+		if fresh {
+			return total, fmt.Errorf("%s:%s changed while it was being downloaded; try again", c.Bucket, key)
+		}
+		return total, fmt.Errorf("%s:%s has changed since %s was partially downloaded; download it again without --continue", c.Bucket, key, to)
+	}
+	if err != nil {
+		return total, fmt.Errorf("%s (run `s3 get --continue` to pick up where this left off)", err)
+	}
+	if err := f.Close(); err != nil {
+		return total, err
+	}
+	j.remove()
+	return total, nil
+}
+
+// streamParts downloads an object to standard output, in parallel
+// ranged parts.  Those can arrive in any order, but have to be
+// written out in order, so each one is held onto until the parts
+// before it have been written.  Since parts are handed out in order,
+// and there are only so many buffers, the parts being held back
+// never take up more than a few parts' worth of memory.
+func streamParts(c *s3.Client, key string, info objectInfo, o downloadOptions) (int64, error) {
+	d := newDownloadCheck(info)
+	w := &inOrder{out: io.MultiWriter(os.Stdout, d), next: 1}
+	w.cond = sync.NewCond(&w.lock)
+
+	part := 0
+	parts := int((info.ContentLength + o.PartSize - 1) / o.PartSize)
+	debugf("streaming @Y{%s}:@C{%s} to @G{standard output} in @W{%d} part(s), @W{%d} at a time", c.Bucket, key, parts, o.Threads)
+	total, err := fetchParts(c, key, `"`+info.ETag+`"`, info.ContentLength, o.PartSize, o.Threads, func() int {
+		if part < parts {
+			part++
+			return part
+		}
+		return 0
+	}, w.write)
+
+	if err == errNoRanges && total == 0 {
+		return 0, err
+	}
+	if err == errObjectChanged {
+		return total, fmt.Errorf("%s:%s changed while it was being downloaded; try again", c.Bucket, key)
+	}
+	if err != nil {
+		return total, err
+	}
+	return total, d.check()
+}
+
+// An inOrder puts parts back in order, by making each one wait its
+// turn to be written out.
+type inOrder struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	out  io.Writer
+	next int
+	err  error
+}
+
+func (w *inOrder) write(n int, data []byte, err error) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	// if any part fails, the parts after it will never get their
+	// turn; wake them all up, so they can give up too.
+	if err != nil {
+		if w.err == nil {
+			w.err = err
+		}
+		w.cond.Broadcast()
+		return err
+	}
+
+	for w.next != n && w.err == nil {
+		w.cond.Wait()
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	if _, err := w.out.Write(data); err != nil {
+		w.err = err
+	}
+	w.next++
+	w.cond.Broadcast()
+	return w.err
+}
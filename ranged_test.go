@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newInOrder() (*inOrder, *bytes.Buffer) {
+	var out bytes.Buffer
+	w := &inOrder{out: &out, next: 1}
+	w.cond = sync.NewCond(&w.lock)
+	return w, &out
+}
+
+// waitFor fails the test if wg isn't done within a few seconds, which
+// means that some part is stuck waiting for its turn.
+func waitFor(t *testing.T, wg *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parts are still waiting for their turn")
+	}
+}
+
+func TestInOrder(t *testing.T) {
+	w, out := newInOrder()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for _, n := range []int{3, 1, 2} {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			errs[n] = w.write(n, []byte{byte('0' + n)}, nil)
+		}(n)
+		time.Sleep(10 * time.Millisecond) // so that 3 really does come first.
+	}
+	waitFor(t, &wg)
+
+	for n := 1; n <= 3; n++ {
+		if errs[n] != nil {
+			t.Errorf("part %d failed: %s", n, errs[n])
+		}
+	}
+	if got := out.String(); got != "123" {
+		t.Errorf("parts were written out as %q, wanted %q", got, "123")
+	}
+}
+
+func TestInOrderFailure(t *testing.T) {
+	w, out := newInOrder()
+	if err := w.write(1, []byte("1"), nil); err != nil {
+		t.Fatalf("part 1 failed: %s", err)
+	}
+
+	// part 3 has to wait for part 2, which then fails.
+	var wg sync.WaitGroup
+	var err3 error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err3 = w.write(3, []byte("3"), nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	failed := errors.New("part 2 went wrong")
+	var err2 error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err2 = w.write(2, nil, failed)
+	}()
+	waitFor(t, &wg)
+
+	if err2 != failed {
+		t.Errorf("part 2 returned %v, wanted %v", err2, failed)
+	}
+	if err3 != failed {
+		t.Errorf("part 3 returned %v, wanted %v", err3, failed)
+	}
+	if got := out.String(); got != "1" {
+		t.Errorf("wrote out %q after part 2 failed, wanted just %q", got, "1")
+	}
+
+	// and any parts after that give up straight away.
+	if err := w.write(4, []byte("4"), nil); err != failed {
+		t.Errorf("part 4 returned %v, wanted %v", err, failed)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// go-s3 handles the basics (buckets, puts, gets, flat listings
+// and acls), but keeps its request signing to itself.  Everything
+// it doesn't cover yet goes through request(), which signs with
+// AWS SigV4 using the same credentials and endpoint settings that
+// client() handed to s3.NewClient().
+
+var (
+	ua     *http.Client
+	uaOnce sync.Once
+)
+
+func agent(c *s3.Client) *http.Client {
+	uaOnce.Do(func() {
+		ua = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: c.InsecureSkipVerify,
+				},
+			},
+		}
+	})
+	return ua
+}
+
+// endpoint builds the URL for a key in a bucket, honoring the
+// --s3-url and --path-buckets settings the same way go-s3 does.
+// The key is percent-encoded per the S3 rules, so that the path
+// we sign is exactly the path we send.
+func endpoint(c *s3.Client, bucket, key string, query url.Values) *url.URL {
+	scheme := c.Protocol
+	if scheme == "" {
+		scheme = "https"
+	}
+	domain := c.Domain
+	if domain == "" {
+		domain = "s3.amazonaws.com"
+	}
+
+	u := &url.URL{Scheme: scheme, Host: domain, Path: "/" + key}
+	if bucket != "" {
+		if c.UsePathBuckets {
+			u.Path = "/" + bucket + u.Path
+		} else {
+			u.Host = bucket + "." + domain
+		}
+	}
+	u.RawPath = uriencode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u
+}
+
+// request submits a signed request for a key in a bucket (unless
+// --no-sign-request was given).  The caller is responsible for
+// closing the response body.
+func request(c *s3.Client, method, bucket, key string, query url.Values, headers http.Header, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint(c, bucket, key, query).String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for header, values := range headers {
+		for _, v := range values {
+			req.Header.Add(header, v)
+		}
+	}
+	req.ContentLength = int64(len(payload))
+	if method == "PUT" && len(payload) > 0 {
+		// i.e. a part of an upload, for --progress to count.
+		req.Body = ioutil.NopCloser(progressReader(req.Body))
+	}
+
+	// public buckets can be read without credentials, so long
+	// as the request isn't signed at all.
+	if !opts.Anonymous {
+		sum := sha256.Sum256(payload)
+		sign(c, req, hex.EncodeToString(sum[:]), time.Now().UTC())
+	}
+
+	traceRequest(req)
+	res, err := agent(c).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	traceResponse(res)
+	return res, nil
+}
+
+// traceRequest and traceResponse log HTTP traffic, if we're
+// logging at the trace level.  Request bodies are only included for
+// POSTs (which carry XML or form data), and response bodies only if
+// they are XML; the rest are the contents of some file.
+func traceRequest(req *http.Request) {
+	if logging(levelTrace) {
+		if what, err := httputil.DumpRequestOut(req, req.Method == "POST"); err == nil {
+			traceHTTP("request", what)
+		}
+	}
+}
+
+func traceResponse(res *http.Response) {
+	if logging(levelTrace) {
+		body := strings.Contains(res.Header.Get("Content-Type"), "xml")
+		if what, err := httputil.DumpResponse(res, body); err == nil {
+			traceHTTP("response", what)
+		}
+	}
+}
+
+// responseError turns a non-2xx response into an error, using the
+// S3 XML error document when there is one (HEAD responses, for
+// example, never have a body).
+func responseError(res *http.Response) error {
+	defer res.Body.Close()
+	var b bytes.Buffer
+	b.ReadFrom(res.Body)
+	if b.Len() == 0 {
+		return fmt.Errorf("%s", res.Status)
+	}
+	return s3.ResponseErrorFrom(b.Bytes())
+}
+
+func sign(c *s3.Client, req *http.Request, payload string, now time.Time) {
+	signWith(currentCredentials(), c.Region, "s3", req, payload, now)
+}
+
+// signWith signs a request for any AWS service (not just S3) with
+// a given set of credentials.
+func signWith(cr credentials, region, service string, req *http.Request, payload string, now time.Time) {
+	yyyymmdd := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", yyyymmdd, region, service)
+
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if cr.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", cr.Token)
+	}
+
+	signed, headers := canonicalHeaders(req)
+	canon := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers,
+		signed,
+		payload,
+	}, "\n")
+
+	sig := signature(cr.SecretAccessKey, yyyymmdd, region, service, strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		sha256hex([]byte(canon)),
+	}, "\n"))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256"+
+		" Credential="+cr.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signed+
+		", Signature="+sig)
+}
+
+// canonicalHeaders returns the SignedHeaders list and the
+// CanonicalHeaders block for a request: the Host header, plus
+// everything in the x-amz-* namespace, plus Content-MD5 / Type.
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	names := []string{"host"}
+	for header := range req.Header {
+		lc := strings.ToLower(header)
+		if strings.HasPrefix(lc, "x-amz-") || lc == "content-md5" || lc == "content-type" {
+			names = append(names, lc)
+			values[lc] = strings.TrimSpace(strings.Join(req.Header.Values(header), ","))
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + values[name] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		vv := append([]string{}, query[k]...)
+		sort.Strings(vv)
+		for _, v := range vv {
+			pairs = append(pairs, uriencode(k, true)+"="+uriencode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func signature(secret, yyyymmdd, region, service, cleartext string) string {
+	k := mac256([]byte("AWS4"+secret), []byte(yyyymmdd))
+	k = mac256(k, []byte(region))
+	k = mac256(k, []byte(service))
+	k = mac256(k, []byte("aws4_request"))
+	return hex.EncodeToString(mac256(k, []byte(cleartext)))
+}
+
+func mac256(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func sha256hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriencode percent-encodes everything outside of the RFC 3986
+// unreserved set, which is what SigV4 expects.  Forward slashes
+// are left alone in paths, but encoded in query strings.
+func uriencode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+	}
+	return b.String()
+}
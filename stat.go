@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// An objectInfo is everything a HEAD request tells us about an
+// object.  Optional headers that weren't in the response are left
+// empty (and come out as null in JSON, or not at all in tables).
+type objectInfo struct {
+	Bucket        string
+	Key           string
+	ContentType   string
+	ContentLength int64
+	ETag          string
+	LastModified  time.Time
+	StorageClass  string
+	VersionID     string
+
+	SSE                  string
+	SSEKMSKeyID          string
+	SSECustomerAlgorithm string
+
+	Checksums    map[string]string // by algorithm, i.e. "sha256"
+	ChecksumType string
+
+	CacheControl       string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	Expires            string
+
+	Metadata map[string]string
+}
+
+func stat(c *s3.Client, bucket, key string) (objectInfo, error) {
+	h, err := head(c, bucket, key)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	return infoFrom(bucket, key, h), nil
+}
+
+// infoFrom reads an objectInfo out of the headers of a HEAD (or GET)
+// response for an object.
+func infoFrom(bucket, key string, h http.Header) objectInfo {
+	info := objectInfo{
+		Bucket:       bucket,
+		Key:          key,
+		ContentType:  h.Get("Content-Type"),
+		ETag:         strings.Trim(h.Get("ETag"), `"`),
+		StorageClass: h.Get("X-Amz-Storage-Class"),
+		VersionID:    h.Get("X-Amz-Version-Id"),
+
+		SSE:                  h.Get("X-Amz-Server-Side-Encryption"),
+		SSEKMSKeyID:          h.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		SSECustomerAlgorithm: h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"),
+
+		CacheControl:       h.Get("Cache-Control"),
+		ContentEncoding:    h.Get("Content-Encoding"),
+		ContentDisposition: h.Get("Content-Disposition"),
+		ContentLanguage:    h.Get("Content-Language"),
+		Expires:            h.Get("Expires"),
+
+		Checksums:    checksumsFrom(h.Get),
+		ChecksumType: h.Get("X-Amz-Checksum-Type"),
+
+		Metadata: make(map[string]string),
+	}
+	info.ContentLength, _ = strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	info.LastModified, _ = http.ParseTime(h.Get("Last-Modified"))
+
+	// S3 leaves the storage class out for STANDARD objects.
+	if info.StorageClass == "" {
+		info.StorageClass = "STANDARD"
+	}
+
+	for k := range h {
+		if lc := strings.ToLower(k); strings.HasPrefix(lc, "x-amz-meta-") {
+			info.Metadata[strings.TrimPrefix(lc, "x-amz-meta-")] = h.Get(k)
+		}
+	}
+	return info
+}
+
+// fields lists the object's attributes, as (name, value) pairs, in
+// the order we want to show them.  Metadata comes last, as one field
+// per x-amz-meta-* header.
+func (info objectInfo) fields() ([]string, []interface{}) {
+	names := []string{
+		"bucket", "key", "content_type", "content_length", "etag",
+		"last_modified", "storage_class", "version_id",
+		"sse", "sse_kms_key_id", "sse_customer_algorithm",
+		"checksum_crc32", "checksum_crc32c", "checksum_sha1", "checksum_sha256", "checksum_type",
+		"cache_control", "content_encoding", "content_disposition",
+		"content_language", "expires",
+	}
+	values := []interface{}{
+		info.Bucket, info.Key, info.ContentType, info.ContentLength, info.ETag,
+		info.LastModified, info.StorageClass, orNil(info.VersionID),
+		orNil(info.SSE), orNil(info.SSEKMSKeyID), orNil(info.SSECustomerAlgorithm),
+		orNil(info.Checksums["crc32"]), orNil(info.Checksums["crc32c"]), orNil(info.Checksums["sha1"]), orNil(info.Checksums["sha256"]), orNil(info.ChecksumType),
+		orNil(info.CacheControl), orNil(info.ContentEncoding), orNil(info.ContentDisposition),
+		orNil(info.ContentLanguage), orNil(info.Expires),
+	}
+
+	keys := make([]string, 0, len(info.Metadata))
+	for k := range info.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		names = append(names, "x-amz-meta-"+k)
+		values = append(values, info.Metadata[k])
+	}
+	return names, values
+}
+
+// MarshalJSON renders the object as a single JSON object, with the
+// user metadata nested under "metadata", rather than flattened out
+// like it is for the other --output formats.
+func (info objectInfo) MarshalJSON() ([]byte, error) {
+	names, values := info.fields()
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, name := range names {
+		if strings.HasPrefix(name, "x-amz-meta-") {
+			break
+		}
+		v, err := json.Marshal(plain(values[i]))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%q:%s,", name, v)
+	}
+	meta, err := json.Marshal(info.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&b, "\"metadata\":%s}", meta)
+	return []byte(b.String()), nil
+}
+
+// print shows the object's attributes in the --output format
+// the user asked for.
+func (info objectInfo) print() error {
+	names, values := info.fields()
+
+	switch opts.Output {
+	case "table":
+		width := 0
+		for _, name := range names {
+			width = max(width, len(name))
+		}
+		for i, name := range names {
+			if values[i] == nil {
+				continue
+			}
+			v := values[i]
+			if t, ok := v.(time.Time); ok {
+				v = t.UTC().Format(time.RFC1123)
+			}
+			fmt.Printf("@C{%-*s}  %v\n", width, name, v)
+		}
+		return nil
+
+	case "json", "jsonl":
+		var b []byte
+		var err error
+		if opts.Output == "json" {
+			b, err = json.MarshalIndent(info, "", "  ")
+		} else {
+			b, err = json.Marshal(info)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", b)
+		return nil
+
+	default:
+		cols := make([]column, len(names))
+		for i, name := range names {
+			cols[i] = column{Field: name}
+		}
+		r := render(cols...)
+		r.Row(values...)
+		return r.Close()
+	}
+}
+
+// exists reports whether a key exists in a bucket.  Anything
+// other than a 404 is an error, since we can't tell either way.
+func exists(c *s3.Client, bucket, key string) (bool, error) {
+	res, err := request(c, "HEAD", bucket, key, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	switch res.StatusCode {
+	case 200:
+		res.Body.Close()
+		return true, nil
+	case 404:
+		res.Body.Close()
+		return false, nil
+	default:
+		return false, responseError(res)
+	}
+}
+
+func orNil(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
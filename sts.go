@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// STS (the AWS Security Token Service) hands out temporary
+// credentials for IAM roles, and tells you who you are.  It speaks
+// a form-encoded query API, with XML responses, signed with SigV4
+// just like S3 is.
+
+// stsEndpoint returns the URL of the STS API to use: --sts-url, if
+// given, or else the regional AWS endpoint.
+func stsEndpoint(region string) string {
+	if opts.STSURL != "" {
+		return opts.STSURL
+	}
+	return fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+}
+
+// stsCall makes an STS API call with the given credentials, and
+// decodes the XML response into v.
+func stsCall(c *s3.Client, cr credentials, action string, params url.Values, v interface{}) error {
+	form := url.Values{}
+	for k, vv := range params {
+		form[k] = vv
+	}
+	form.Set("Action", action)
+	form.Set("Version", "2011-06-15")
+	payload := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", stsEndpoint(c.Region), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signWith(cr, c.Region, "sts", req, sha256hex(payload), time.Now().UTC())
+
+	debugf("calling STS @C{%s} at @G{%s}", action, req.URL)
+	traceRequest(req)
+	res, err := agent(c).Do(req)
+	if err != nil {
+		return err
+	}
+	traceResponse(res)
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return fmt.Errorf("sts %s failed: %s (%s)", action, e.Message, e.Code)
+		}
+		return fmt.Errorf("sts %s failed: %s", action, res.Status)
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// An identity is who AWS thinks we are, per GetCallerIdentity.
+type identity struct {
+	Account string `xml:"GetCallerIdentityResult>Account"`
+	ARN     string `xml:"GetCallerIdentityResult>Arn"`
+	UserID  string `xml:"GetCallerIdentityResult>UserId"`
+}
+
+func whoami(c *s3.Client) (identity, error) {
+	var id identity
+	err := stsCall(c, currentCredentials(), "GetCallerIdentity", nil, &id)
+	return id, err
+}
+
+// print shows who we are, and which credentials say so, in the
+// --output format the user asked for.
+func (id identity) print(c *s3.Client) error {
+	names := []string{"account", "arn", "user_id", "access_key_id", "credentials"}
+	values := []interface{}{id.Account, id.ARN, id.UserID, currentCredentials().AccessKeyID, credentialSource}
+
+	switch opts.Output {
+	case "table":
+		for i, name := range names {
+			fmt.Printf("@C{%-13s}  %v\n", name, values[i])
+		}
+		return nil
+
+	case "json", "jsonl":
+		m := make(map[string]interface{})
+		for i, name := range names {
+			m[name] = values[i]
+		}
+		var b []byte
+		var err error
+		if opts.Output == "json" {
+			b, err = json.MarshalIndent(m, "", "  ")
+		} else {
+			b, err = json.Marshal(m)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", b)
+		return nil
+
+	default:
+		cols := make([]column, len(names))
+		for i, name := range names {
+			cols[i] = column{Field: name}
+		}
+		r := render(cols...)
+		r.Row(values...)
+		return r.Close()
+	}
+}
+
+// A roleRequest is everything we need to assume a role.
+type roleRequest struct {
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	MFASerial   string
+	MFAToken    string
+}
+
+// assumeRole trades the client's (base) credentials for temporary
+// credentials for the requested role.  Those are cached on disk,
+// and reused until they are about to expire, so that we don't have
+// to go back to STS (or ask for another MFA code) on every run.
+func assumeRole(c *s3.Client, base credentials, r roleRequest) (credentials, error) {
+	cache := r.cacheFile(base)
+	if cr, ok := cachedCredentials(cache); ok {
+		debugf("using cached credentials for role @G{%s} from @C{%s} (good until %s)", r.RoleARN, cache, cr.Expires)
+		return cr, nil
+	}
+
+	params := url.Values{}
+	params.Set("RoleArn", r.RoleARN)
+	params.Set("RoleSessionName", r.SessionName)
+	if r.ExternalID != "" {
+		params.Set("ExternalId", r.ExternalID)
+	}
+	if r.MFASerial != "" {
+		if r.MFAToken == "" {
+			return credentials{}, fmt.Errorf("role %s needs an MFA code; please supply one via --mfa-token", r.RoleARN)
+		}
+		params.Set("SerialNumber", r.MFASerial)
+		params.Set("TokenCode", r.MFAToken)
+	}
+
+	var res struct {
+		AccessKeyID     string    `xml:"AssumeRoleResult>Credentials>AccessKeyId"`
+		SecretAccessKey string    `xml:"AssumeRoleResult>Credentials>SecretAccessKey"`
+		SessionToken    string    `xml:"AssumeRoleResult>Credentials>SessionToken"`
+		Expiration      time.Time `xml:"AssumeRoleResult>Credentials>Expiration"`
+	}
+	if err := stsCall(c, base, "AssumeRole", params, &res); err != nil {
+		return credentials{}, err
+	}
+	cr := credentials{
+		AccessKeyID:     res.AccessKeyID,
+		SecretAccessKey: res.SecretAccessKey,
+		Token:           res.SessionToken,
+		Expires:         res.Expiration,
+	}
+	debugf("assumed role @G{%s} (good until %s)", r.RoleARN, cr.Expires)
+
+	if err := saveCredentials(cache, cr); err != nil {
+		warnf("unable to cache role credentials in %s: %s", cache, err)
+	}
+	return cr, nil
+}
+
+// cacheFile names the on-disk cache for a role's credentials.  The
+// name is a hash of everything that went into asking for them, so
+// that different roles (or the same role, assumed by different
+// users) don't trample on one another.
+func (r roleRequest) cacheFile(base credentials) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		base.AccessKeyID, r.RoleARN, r.SessionName, r.ExternalID, r.MFASerial,
+	}, "\n")))
+
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(dir, "s3", "sts", hex.EncodeToString(sum[:16])+".json")
+}
+
+// cachedCredentials reads credentials back out of the cache, so
+// long as they are good for a while yet.
+func cachedCredentials(file string) (credentials, bool) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return credentials{}, false
+	}
+	var cr credentials
+	if err := json.Unmarshal(b, &cr); err != nil {
+		return credentials{}, false
+	}
+	if time.Until(cr.Expires) < 5*time.Minute {
+		return credentials{}, false
+	}
+	return cr, true
+}
+
+// saveCredentials writes credentials to the cache.  They are just as
+// secret as anything in the configuration file, so only the owner
+// gets to read them.
+func saveCredentials(file string, cr credentials) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cr)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+// roleProvider wraps assumeRole up as a credential provider, so that
+// keepFresh can re-assume the role when the credentials run out.
+// The base credentials are re-fetched first, in case they are also
+// temporary.
+func roleProvider(c *s3.Client, base provider, cr credentials, r roleRequest) provider {
+	return provider{
+		Name: "assumed role " + r.RoleARN,
+		Fetch: func() (credentials, bool, error) {
+			if base.Fetch != nil {
+				next, ok, err := base.Fetch()
+				if err != nil || !ok {
+					return credentials{}, ok, err
+				}
+				cr = next
+			}
+			next, err := assumeRole(c, cr, r)
+			return next, err == nil, err
+		},
+	}
+}
+
+// defaultSessionName is the role session name to use if none was
+// given.  It has to be stable from one run to the next, or else the
+// cached credentials would never get used.
+func defaultSessionName() string {
+	name := "s3"
+	if u := os.Getenv("USER"); u != "" {
+		name += "-" + u
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+=,.@-", r) {
+			return r
+		}
+		return '-'
+	}, name)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// parseS3URL splits an s3://bucket/path/to/key URL into its
+// bucket and key components.  ok is false if s isn't an s3:// URL.
+func parseS3URL(s string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", false
+	}
+	l := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if len(l) == 1 {
+		return l[0], "", true
+	}
+	return l[0], l[1], true
+}
+
+// A filter decides which files an operation applies to, based on
+// the --include and --exclude glob patterns.  A path is matched if
+// it matches at least one include pattern (or there are none), and
+// no exclude patterns.
+//
+// Patterns use path.Match syntax, against the slash-separated path
+// relative to the top of the tree; patterns without a slash are
+// also tried against the file's base name, so that "*.log" does
+// what you would expect.  Since a * never matches a slash, patterns
+// ending in "/" or "/*" (like "logs/*") match everything under that
+// directory, however deep.
+type filter struct {
+	Include []string
+	Exclude []string
+}
+
+func (f filter) match(rel string) bool {
+	for _, p := range f.Exclude {
+		if glob(p, rel) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, p := range f.Include {
+		if glob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func glob(pattern, rel string) bool {
+	if ok, _ := path.Match(pattern, rel); ok {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/") || strings.HasSuffix(pattern, "/*") {
+		dir := pattern[:strings.LastIndex(pattern, "/")]
+		n := strings.Count(dir, "/") + 1
+		if l := strings.SplitN(rel, "/", n+1); len(l) > n {
+			ok, _ := path.Match(dir, strings.Join(l[:n], "/"))
+			return ok
+		}
+		return false
+	}
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+	return false
+}
+
+// A localFile is a regular file found under some local directory.
+// Rel is its slash-separated path relative to that directory.
+type localFile struct {
+	Rel  string
+	Path string
+	Info os.FileInfo
+}
+
+// localFiles walks the directory tree under root, returning all of
+// the regular files that the filter lets through, sorted by relative
+// path.  Devices, sockets and the like are always skipped; symbolic
+// links are skipped too, unless follow is set, in which case they
+// are treated as the file (or directory) they point to.
+func localFiles(root string, f filter, follow bool) ([]localFile, error) {
+	w := walker{filter: f, follow: follow, seen: make(map[string]bool)}
+	err := w.walk(root, "")
+
+	sort.Slice(w.files, func(i, j int) bool { return w.files[i].Rel < w.files[j].Rel })
+	return w.files, err
+}
+
+type walker struct {
+	filter filter
+	follow bool
+	seen   map[string]bool
+	files  []localFile
+}
+
+func (w *walker) walk(dir, prefix string) error {
+	// keep track of where we've been, so that a symbolic
+	// link back up the tree doesn't send us round in circles.
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if w.seen[real] {
+		debugf("  - skipping @C{%s} (already visited)", dir)
+		return nil
+	}
+	w.seen[real] = true
+
+	return filepath.Walk(real, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(real, p)
+		if err != nil {
+			return err
+		}
+		rel = path.Join(prefix, filepath.ToSlash(rel))
+
+		if info.Mode()&os.ModeSymlink != 0 && w.follow {
+			target, err := os.Stat(p)
+			if err != nil {
+				warnf("skipping @C{%s} (%s)", p, err)
+				return nil
+			}
+			if target.IsDir() {
+				return w.walk(p, rel)
+			}
+			info = target
+		}
+
+		if !info.Mode().IsRegular() {
+			if !info.IsDir() {
+				debugf("  - skipping @C{%s} (not a regular file)", p)
+			}
+			return nil
+		}
+
+		if w.filter.match(rel) {
+			w.files = append(w.files, localFile{Rel: rel, Path: p, Info: info})
+		}
+		return nil
+	})
+}
+
+// localPath maps a slash-separated path, relative to some prefix in
+// a bucket, onto the local filesystem under root.  Paths that would
+// escape root (via .. components, for example) are refused.
+func localPath(root, rel string) (string, error) {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(root, p); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to write '%s' outside of %s", rel, root)
+	}
+	return p, nil
+}
+
+// remoteFiles lists every object under prefix in the client's
+// bucket that the filter lets through, keyed by its path relative
+// to the prefix.  "Directory" placeholder keys are skipped.
+func remoteFiles(c *s3.Client, prefix string, f filter) (map[string]s3.Object, error) {
+	files := make(map[string]s3.Object)
+	l := listing{Bucket: c.Bucket, Prefix: prefix}
+	err := l.eachObject(c, func(o s3.Object) error {
+		rel := strings.TrimPrefix(o.Key, prefix)
+		if rel != "" && !strings.HasSuffix(rel, "/") && f.match(rel) {
+			files[rel] = o
+		}
+		return nil
+	})
+	return files, err
+}
+
+func fileMD5(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// differs reports whether a local file and a remote object hold
+// different content.  Sizes are compared first.  If those match, we
+// compare the local file's MD5 against the object's, if we can know
+// it: from a plain ETag (unless the object turns out to be encrypted
+// in a way that makes its ETag opaque), or from the metadata that
+// `put` leaves on objects it uploads in parts.  Otherwise, all we
+// have to go on are modification times; `upload` says which side has
+// to be newer to be worth copying.
+func (s syncer) differs(local localFile, remote s3.Object, upload bool) (bool, error) {
+	if local.Info.Size() != int64(remote.Size) {
+		return true, nil
+	}
+
+	sum, err := fileMD5(local.Path)
+	if err != nil {
+		return false, err
+	}
+	if plainETag.MatchString(remote.ETag) && sum == remote.ETag {
+		return false, nil
+	}
+
+	/* a listing can't tell us about encryption or metadata */
+	info, err := stat(s.c, s.c.Bucket, remote.Key)
+	if err != nil {
+		return false, err
+	}
+	if m := info.md5(); m != nil {
+		return sum != hex.EncodeToString(m), nil
+	}
+	debugf("no MD5 for @C{%s:%s} (its ETag is @W{%s}); comparing modification times", s.c.Bucket, remote.Key, info.ETag)
+
+	if upload {
+		return local.Info.ModTime().After(remote.LastModified), nil
+	}
+	return remote.LastModified.After(local.Info.ModTime()), nil
+}
+
+type syncer struct {
+	c        *s3.Client
+	dir      string
+	prefix   string
+	filter   filter
+	delete   bool
+	dryRun   bool
+	parallel int
+	partSize int64
+}
+
+func (s syncer) say(m string, args ...interface{}) {
+	if s.dryRun {
+		m = "@W{(dry run)} " + m
+	}
+	fmt.Printf(m+"\n", args...)
+}
+
+// up makes the prefix in the bucket look like the local directory.
+func (s syncer) up() error {
+	if info, err := os.Stat(s.dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", s.dir)
+	}
+
+	local, err := localFiles(s.dir, s.filter, false)
+	if err != nil {
+		return err
+	}
+	remote, err := remoteFiles(s.c, s.prefix, s.filter)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range local {
+		key := s.prefix + f.Rel
+		if o, ok := remote[f.Rel]; ok {
+			delete(remote, f.Rel)
+			if diff, err := s.differs(f, o, true); err != nil {
+				return err
+			} else if !diff {
+				debugf("  - skipping unchanged @C{%s}", f.Path)
+				continue
+			}
+		}
+
+		s.say("@G{upload}: @C{%s} -> @Y{%s}:@C{%s}", f.Path, s.c.Bucket, key)
+		if s.dryRun {
+			continue
+		}
+		in, err := os.Open(f.Path)
+		if err != nil {
+			return err
+		}
+		_, err = upload(s.c, in, key, nil, uploadOptions{Threads: s.parallel, PartSize: s.partSize})
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.delete {
+		for _, rel := range sortedKeys(remote) {
+			s.say("@R{delete}: @Y{%s}:@C{%s}", s.c.Bucket, s.prefix+rel)
+			if !s.dryRun {
+				if err := signer(s.c).Delete(s.prefix + rel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// down makes the local directory look like the prefix in the bucket.
+// Downloaded files get the object's Last-Modified time as their mtime,
+// so that later syncs can tell if they have changed.
+func (s syncer) down() error {
+	remote, err := remoteFiles(s.c, s.prefix, s.filter)
+	if err != nil {
+		return err
+	}
+	local, err := localFiles(s.dir, s.filter, false)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	have := make(map[string]localFile)
+	for _, f := range local {
+		have[f.Rel] = f
+	}
+
+	for _, rel := range sortedKeys(remote) {
+		o := remote[rel]
+		if f, ok := have[rel]; ok {
+			delete(have, rel)
+			if diff, err := s.differs(f, o, false); err != nil {
+				return err
+			} else if !diff {
+				debugf("  - skipping unchanged @C{%s}", f.Path)
+				continue
+			}
+		}
+
+		to, err := localPath(s.dir, rel)
+		if err != nil {
+			return err
+		}
+		s.say("@G{download}: @Y{%s}:@C{%s} -> @C{%s}", s.c.Bucket, o.Key, to)
+		if s.dryRun {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+			return err
+		}
+		if _, err := download(s.c, o.Key, to, downloadOptions{Threads: s.parallel, PartSize: s.partSize}); err != nil {
+			return err
+		}
+		if err := os.Chtimes(to, o.LastModified, o.LastModified); err != nil {
+			return err
+		}
+	}
+
+	if s.delete {
+		for _, f := range local {
+			if _, ok := have[f.Rel]; !ok {
+				continue
+			}
+			s.say("@R{delete}: @C{%s}", f.Path)
+			if !s.dryRun {
+				if err := os.Remove(f.Path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]s3.Object) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
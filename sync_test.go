@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	root := filepath.FromSlash("/tmp/restore")
+	tests := []struct {
+		rel  string
+		path string // or "", if it should be refused
+	}{
+		{"x", "/tmp/restore/x"},
+		{"a/b/c.txt", "/tmp/restore/a/b/c.txt"},
+		{"a/../b", "/tmp/restore/b"},
+		{"a//b", "/tmp/restore/a/b"},
+		{"..x", "/tmp/restore/..x"},
+		{"../x", ""},
+		{"a/../../x", ""},
+		{"..", ""},
+		{"a/b/../../../restore2/x", ""},
+	}
+
+	for _, test := range tests {
+		got, err := localPath(root, test.rel)
+		if test.path == "" {
+			if err == nil {
+				t.Errorf("localPath(%q) = %q, should have been refused", test.rel, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("localPath(%q) was refused: %s", test.rel, err)
+		} else if want := filepath.FromSlash(test.path); got != want {
+			t.Errorf("localPath(%q) = %q, wanted %q", test.rel, got, want)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter filter
+		rel    string
+		match  bool
+	}{
+		{filter{}, "anything/at/all", true},
+
+		{filter{Include: []string{"*.log"}}, "app.log", true},
+		{filter{Include: []string{"*.log"}}, "var/log/app.log", true},
+		{filter{Include: []string{"*.log"}}, "app.log.gz", false},
+
+		{filter{Include: []string{"logs/*"}}, "logs/a.gz", true},
+		{filter{Include: []string{"logs/*"}}, "logs/2026/a.gz", true},
+		{filter{Include: []string{"logs/*"}}, "logs", false},
+		{filter{Include: []string{"logs/*"}}, "logsx/a.gz", false},
+		{filter{Include: []string{"logs/*"}}, "old/logs/a.gz", false},
+		{filter{Include: []string{"logs/"}}, "logs/2026/01/a.gz", true},
+		{filter{Include: []string{"logs/*.gz"}}, "logs/a.gz", true},
+		{filter{Include: []string{"logs/*.gz"}}, "logs/2026/a.gz", false},
+		{filter{Include: []string{"build/*/out/"}}, "build/linux/out/bin/s3", true},
+		{filter{Include: []string{"build/*/out/"}}, "build/linux/tmp/s3", false},
+
+		{filter{Exclude: []string{"*.tmp"}}, "a/b.tmp", false},
+		{filter{Exclude: []string{"*.tmp"}}, "a/b.txt", true},
+		{filter{Exclude: []string{"cache/"}}, "cache/x/y", false},
+		{filter{Exclude: []string{"cache/"}}, "src/cache.go", true},
+
+		{filter{Include: []string{"src/*"}, Exclude: []string{"*_test.go"}}, "src/a/main.go", true},
+		{filter{Include: []string{"src/*"}, Exclude: []string{"*_test.go"}}, "src/a/main_test.go", false},
+		{filter{Include: []string{"src/*"}, Exclude: []string{"*_test.go"}}, "docs/a.md", false},
+		{filter{Include: []string{"*.md", "*.txt"}}, "docs/notes.txt", true},
+	}
+
+	for _, test := range tests {
+		if got := test.filter.match(test.rel); got != test.match {
+			t.Errorf("%+v.match(%q) = %v, wanted %v", test.filter, test.rel, got, test.match)
+		}
+	}
+}
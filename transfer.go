@@ -0,0 +1,839 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-s3"
+)
+
+// Parts of a multipart upload (except for the last one) have to be
+// at least 5MiB, and at most 5GiB, and there can be no more than
+// 10,000 of them.  5MiB parts are the default; bigger files get
+// bigger parts, so that they fit.
+const (
+	uploadPartSize = 5 * (1 << 20)
+	maxPartSize    = 5 * (1 << 30)
+	maxParts       = 10000
+)
+
+// filePartSize picks the part size for uploading a file of a known
+// size: `want` (the --part-size, or 0 for the default), unless that
+// would take too many parts, in which case it is the smallest part
+// size (in whole MiB) that doesn't.
+func filePartSize(size, want int64) int64 {
+	part := want
+	if part == 0 {
+		part = uploadPartSize
+	}
+	need := (size + maxParts - 1) / maxParts
+	if need <= part {
+		return part
+	}
+
+	need = (need + (1 << 20) - 1) / (1 << 20) * (1 << 20)
+	if want != 0 {
+		warnf("--part-size %s is too small for a %s file (it would take more than %d parts); using %s parts instead", s3.Bytes(want), s3.Bytes(size), maxParts, s3.Bytes(need))
+	} else {
+		debugf("using @W{%s} parts to upload a @W{%s} file in @W{%d} parts or less", s3.Bytes(need), s3.Bytes(size), maxParts)
+	}
+	return need
+}
+
+// streamPartSize is how big part n of an upload of unknown size
+// (i.e. from standard input) should be.  There's no telling how many
+// parts we'll need, so they start out at `base` (the --part-size, or
+// 5MiB), and double every 1,000 parts.  Doubling alone would leave
+// the 10,000 parts that S3 allows just short of 5TiB (as big as an
+// S3 object can get), so the last 1,000 parts are as big as S3 allows.
+func streamPartSize(n int, base int64) int64 {
+	if base == 0 {
+		base = uploadPartSize
+	}
+	if n > maxParts-1000 {
+		return maxPartSize
+	}
+	size := base << uint((n-1)/1000)
+	if size > maxPartSize || size < base {
+		return maxPartSize
+	}
+	return size
+}
+
+// uploadOptions says how upload() should go about it.
+type uploadOptions struct {
+	Threads  int                // how many parts to send at once
+	PartSize int64              // how big those parts are (0 for the default)
+	Checksum *checksumAlgorithm // flexible checksum to send, if any
+}
+
+// upload sends everything read from `in` to the given key in the
+// client's bucket, as a multipart upload spread across o.Threads
+// parallel i/o threads, in parts of (at least) o.PartSize bytes.
+// The object gets the given headers (metadata, Cache-Control, etc.);
+// if they don't include a Content-Type, it is detected from the
+// first 512 bytes of input.
+//
+// Regular files are journaled as they go up, so that an interrupted
+// upload can be picked up again later (see uploadFile).  Anything
+// else (i.e. standard input) is streamed, and if that fails, the
+// upload is aborted, since there's no getting that data back.
+func upload(c *s3.Client, in io.Reader, to string, headers http.Header, o uploadOptions) (n int64, err error) {
+	stop := noProgress
+	defer func() { stop(err) }()
+
+	if f, ok := in.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			return uploadFile(c, f, info, to, headers, o)
+		}
+	}
+
+	preamble := make([]byte, 512)
+	l, err := io.ReadFull(in, preamble)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	preamble = preamble[:l]
+
+	headers = contentType(headers, to, preamble)
+	if l == 0 {
+		return 0, uploadEmpty(c, to, headers, o.Checksum)
+	}
+
+	debugf("@C{%s}: uploading @M{%s} file", to, headers.Get("Content-Type"))
+	m, err := startMultipart(c, c.Bucket, to, headers, o.Checksum)
+	if err != nil {
+		return 0, err
+	}
+
+	in = io.MultiReader(bytes.NewReader(preamble), in)
+	stop = startProgress("upload", c.Bucket, to, -1, 0)
+	part := 0
+	total, err := sendParts(m, streamPartSize(1, o.PartSize), o.Threads, func(buf []byte) (int, []byte, error) {
+		size := streamPartSize(part+1, o.PartSize)
+		if int64(cap(buf)) < size {
+			debugf("@C{%s}: growing parts to @W{%s}, from part @W{%d} on", to, s3.Bytes(size), part+1)
+			buf = make([]byte, size)
+		}
+		n, err := io.ReadFull(in, buf[:size])
+		if err == io.EOF {
+			return 0, nil, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, nil, err
+		}
+		if part == maxParts {
+			return 0, nil, fmt.Errorf("too much data; S3 only allows %d parts per upload (try a bigger --part-size)", maxParts)
+		}
+		part++
+		return part, buf[:n], nil
+	}, nil)
+	if err != nil {
+		if err := m.abort(); err != nil {
+			warnf("unable to abort multipart upload %s: %s", m.ID, err)
+		}
+		return total, err
+	}
+	return total, m.complete()
+}
+
+// uploadFile uploads a regular file, keeping a journal of which
+// parts have been uploaded.  With --resume, the parts that S3
+// already has from an earlier, interrupted attempt are skipped.
+// Without it, any earlier attempt is aborted, and we start over.
+func uploadFile(c *s3.Client, f *os.File, info os.FileInfo, to string, headers http.Header, o uploadOptions) (n int64, err error) {
+	stop := noProgress
+	defer func() { stop(err) }()
+
+	size := info.Size()
+	preamble := make([]byte, 512)
+	l, err := f.ReadAt(preamble, 0)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	headers = contentType(headers, to, preamble[:l])
+	if size == 0 {
+		return 0, uploadEmpty(c, to, headers, o.Checksum)
+	}
+
+	path, err := filepath.Abs(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	source := fingerprintOf(path, info)
+
+	file := journalFile(c, c.Bucket, to, path)
+	j, err := loadJournal(file)
+	if err != nil {
+		return 0, err
+	}
+
+	var m *multipart
+	if j != nil && !opts.Upload.Resume {
+		warnf("abandoning an earlier, interrupted upload of @C{%s} (use --resume to continue it instead)", path)
+		abandon(c, j)
+		j = nil
+	}
+	if j != nil {
+		m, err = resume(c, j, source, o.Checksum)
+		if err != nil {
+			return 0, err
+		}
+	} else if opts.Upload.Resume {
+		debugf("@C{%s}: no interrupted upload to resume; starting from scratch", path)
+	}
+
+	if m == nil {
+		// the ETag (and checksum) of a multipart upload isn't the
+		// MD5 (or checksum) of the file, so we store those in the
+		// metadata, for `get` to check.
+		if err := wholeFileSums(f, size, headers, o.Checksum); err != nil {
+			return 0, err
+		}
+
+		debugf("@C{%s}: uploading @M{%s} file", to, headers.Get("Content-Type"))
+		m, err = startMultipart(c, c.Bucket, to, headers, o.Checksum)
+		if err != nil {
+			return 0, err
+		}
+		j = &uploadJournal{
+			Endpoint: endpoint(c, c.Bucket, "", nil).Host,
+			Bucket:   c.Bucket,
+			Key:      to,
+			UploadID: m.ID,
+			Source:   source,
+			PartSize: filePartSize(size, o.PartSize),
+			Parts:    make(map[int]savedPart),
+			file:     file,
+		}
+		if o.Checksum != nil {
+			j.Checksum = o.Checksum.Name
+		}
+		if err := j.save(); err != nil {
+			return 0, err
+		}
+	}
+
+	var done int64
+	for _, p := range j.Parts {
+		done += p.Size
+	}
+	stop = startProgress("upload", c.Bucket, to, size, done)
+
+	part := 0
+	parts := int((size + j.PartSize - 1) / j.PartSize)
+	_, err = sendParts(m, j.PartSize, o.Threads, func(buf []byte) (int, []byte, error) {
+		for part < parts {
+			part++
+			if j.has(part) {
+				continue
+			}
+			offset := int64(part-1) * j.PartSize
+			n, err := f.ReadAt(buf[:min64(j.PartSize, size-offset)], offset)
+			if err != nil && err != io.EOF {
+				return 0, nil, err
+			}
+			return part, buf[:n], nil
+		}
+		return 0, nil, nil
+	}, func(n int, etag string, size int64) error {
+		return j.record(n, etag, size)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s (run `s3 put --resume` to pick up where this left off)", err)
+	}
+
+	if err := m.complete(); err != nil {
+		return 0, err
+	}
+	j.remove()
+	return size, nil
+}
+
+// resume picks an interrupted upload back up, after checking that
+// the file hasn't changed, and that S3 still has the upload.  The
+// journal is squared with what S3 says it has; only parts that S3
+// has (and that match what we sent) count as uploaded.  If the
+// upload is gone, resume returns nil, and we have to start over.
+//
+// The upload keeps the checksum algorithm it was started with, since
+// S3 won't take parts with any other kind of checksum.
+func resume(c *s3.Client, j *uploadJournal, source fingerprint, checksum *checksumAlgorithm) (*multipart, error) {
+	if !j.Source.same(source) {
+		return nil, fmt.Errorf("%s has changed since it was last uploaded; run without --resume to upload it from scratch", source.Path)
+	}
+
+	m := &multipart{c: c, Bucket: j.Bucket, Key: j.Key, ID: j.UploadID}
+	if j.Checksum != "" {
+		a, err := checksumAlgorithmNamed(j.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		m.Checksum = a
+	}
+	if m.Checksum != checksum {
+		what := "no checksum"
+		if m.Checksum != nil {
+			what = "a " + m.Checksum.String() + " checksum"
+		}
+		warnf("interrupted upload @M{%s} of @C{%s} was started with %s; resuming it with that", j.UploadID, source.Path, what)
+	}
+	uploaded, err := m.listParts()
+	if err == errNoSuchUpload {
+		warnf("interrupted upload @M{%s} of @C{%s} no longer exists; starting from scratch", j.UploadID, source.Path)
+		j.remove()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	saved := j.Parts
+	j.Parts = make(map[int]savedPart)
+	for _, p := range uploaded {
+		want := min64(j.PartSize, source.Size-int64(p.PartNumber-1)*j.PartSize)
+		if s, ok := saved[p.PartNumber]; !ok || s.ETag != p.ETag || p.Size != want {
+			continue
+		}
+		// these parts were checked (via Content-MD5) when they went
+		// up, so their ETags are as good as our own MD5s.
+		var sum []byte
+		if etag := hexETag(p.ETag); plainETag.MatchString(etag) {
+			sum, _ = hex.DecodeString(etag)
+		}
+		checksum := ""
+		if m.Checksum != nil {
+			if checksum = p.get(m.Checksum); checksum == "" {
+				// S3 should have it, but without it, we can't work
+				// out the checksum of the finished object.
+				debugf("part @W{%d} of @M{%s} has no %s checksum", p.PartNumber, j.UploadID, m.Checksum)
+			}
+		}
+		m.done(p.PartNumber, p.ETag, sum, checksum)
+		j.Parts[p.PartNumber] = savedPart{ETag: p.ETag, Size: p.Size}
+	}
+
+	total := (source.Size + j.PartSize - 1) / j.PartSize
+	debugf("resuming upload @M{%s} of @C{%s}: @G{%d} of @G{%d} part(s) already uploaded", j.UploadID, source.Path, len(j.Parts), total)
+	return m, j.save()
+}
+
+// abandon aborts an interrupted upload, and throws away its journal.
+func abandon(c *s3.Client, j *uploadJournal) {
+	m := &multipart{c: c, Bucket: j.Bucket, Key: j.Key, ID: j.UploadID}
+	if err := m.abort(); err != nil {
+		warnf("unable to abort multipart upload %s: %s", j.UploadID, err)
+	}
+	j.remove()
+}
+
+// sendParts uploads the parts handed out by `next`, `threads` at a
+// time, until it runs out (returning a part number of 0), or until
+// something goes wrong.  Each part is read into one of a handful of
+// part-sized buffers, which are reused once their part has been
+// sent, to keep memory usage in check.  (If `next` needs a bigger
+// buffer than it was given, it can swap in its own, which is then
+// reused in place of the smaller one.)  If given, `sent` is called
+// for each part that makes it up.
+func sendParts(m *multipart, size int64, threads int, next func([]byte) (int, []byte, error), sent func(int, string, int64) error) (int64, error) {
+	type job struct {
+		n    int
+		data []byte
+	}
+
+	var (
+		jobs  = make(chan job)
+		free  = make(chan []byte, threads+1)
+		bufs  int
+		wg    sync.WaitGroup
+		lock  sync.Mutex
+		total int64
+		err   error
+	)
+	fail := func(e error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err == nil {
+			err = e
+		}
+	}
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return err != nil
+	}
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if !failed() {
+					debugf("  - uploading part @W{%d} (%s) of @C{%s}", j.n, s3.Bytes(int64(len(j.data))), m.Key)
+					partsInFlight(1)
+					etag, e := m.uploadPart(j.n, j.data)
+					partsInFlight(-1)
+					if e == nil && sent != nil {
+						e = sent(j.n, etag, int64(len(j.data)))
+					}
+					if e != nil {
+						fail(fmt.Errorf("part %d: %s", j.n, e))
+					} else {
+						lock.Lock()
+						total += int64(len(j.data))
+						lock.Unlock()
+					}
+				}
+				free <- j.data[:cap(j.data)]
+			}
+		}()
+	}
+
+	for !failed() {
+		var buf []byte
+		select {
+		case buf = <-free:
+		default:
+			if bufs <= threads {
+				buf = make([]byte, size)
+				bufs++
+			} else {
+				buf = <-free
+			}
+		}
+
+		n, data, e := next(buf)
+		if e != nil {
+			fail(e)
+			break
+		}
+		if n == 0 {
+			break
+		}
+		jobs <- job{n: n, data: data}
+	}
+	close(jobs)
+	wg.Wait()
+	return total, err
+}
+
+// contentType fills in the Content-Type header, if it wasn't given,
+// by sniffing the first 512 bytes of the file.
+func contentType(headers http.Header, to string, preamble []byte) http.Header {
+	headers = headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if headers.Get("Content-Type") == "" {
+		debugf("@C{%s}: detecting content-type from first 512b", to)
+		headers.Set("Content-Type", http.DetectContentType(preamble))
+	}
+	return headers
+}
+
+// uploadEmpty creates an empty object.  S3 refuses to complete a
+// multipart upload with no parts in it, so empty files have to go
+// up in a single PUT.
+func uploadEmpty(c *s3.Client, to string, headers http.Header, checksum *checksumAlgorithm) error {
+	debugf("@C{%s}: uploading empty @M{%s} file", to, headers.Get("Content-Type"))
+	headers.Set("Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==") // i.e. the MD5 of nothing.
+	if checksum != nil {
+		headers.Set(checksum.Header(), checksum.sum(nil))
+	}
+	res, err := request(c, "PUT", c.Bucket, to, nil, headers, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return responseError(res)
+	}
+	res.Body.Close()
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// uploadHeaders builds the headers for `put` to give each uploaded
+// object, from the --content-type, --meta, --cache-control, etc.
+// command-line options.
+func uploadHeaders() (http.Header, error) {
+	h := http.Header{}
+	if opts.Upload.ContentType != "" {
+		h.Set("Content-Type", opts.Upload.ContentType)
+	}
+	if opts.Upload.CacheControl != "" {
+		h.Set("Cache-Control", opts.Upload.CacheControl)
+	}
+	if opts.Upload.ContentEncoding != "" {
+		h.Set("Content-Encoding", opts.Upload.ContentEncoding)
+	}
+	if opts.Upload.ContentDisposition != "" {
+		h.Set("Content-Disposition", opts.Upload.ContentDisposition)
+	}
+	if opts.Upload.ContentLanguage != "" {
+		h.Set("Content-Language", opts.Upload.ContentLanguage)
+	}
+
+	if opts.Upload.Expires != "" {
+		// S3 wants an HTTP date, but those are a pain to type,
+		// so we also take RFC3339 timestamps, and convert them.
+		if _, err := http.ParseTime(opts.Upload.Expires); err == nil {
+			h.Set("Expires", opts.Upload.Expires)
+		} else if t, err := time.Parse(time.RFC3339, opts.Upload.Expires); err == nil {
+			h.Set("Expires", t.UTC().Format(http.TimeFormat))
+		} else {
+			return nil, fmt.Errorf("invalid --expires '%s' (must be an HTTP date, or an RFC3339 timestamp)", opts.Upload.Expires)
+		}
+	}
+
+	for _, kv := range opts.Upload.Meta {
+		l := strings.SplitN(kv, "=", 2)
+		if len(l) != 2 || l[0] == "" {
+			return nil, fmt.Errorf("invalid --meta '%s' (must be key=value)", kv)
+		}
+		h.Add("X-Amz-Meta-"+l[0], l[1])
+	}
+	return h, nil
+}
+
+// rangeIgnored is what's wrong when we asked for a --range of an
+// object, and the server sent the whole thing back instead.
+func rangeIgnored(c *s3.Client, key, r string) error {
+	return fmt.Errorf("the server does not support ranged downloads, and sent back all of %s:%s instead of just bytes %s", c.Bucket, key, r)
+}
+
+// download retrieves a key (or, given a byte range like "0-99",
+// just part of it) from the client's bucket, writing it to the local
+// file `to`, or to standard output if `to` is "-".  Objects bigger
+// than a single part are fetched in parallel parts (see ranged.go),
+// if we've been given more than one thread to do it with.
+//
+// With `Continue`, a partial download already in `to` is continued
+// from where it left off, rather than started over, so long as the
+// object hasn't changed since.  To make sure of that, downloads to
+// local files are journaled with the ETag of the object, which is
+// then sent back (via If-Match) when continuing.
+func download(c *s3.Client, key, to string, o downloadOptions) (n int64, err error) {
+	// if a parallel download has to fall back to a single stream,
+	// the progress meter it started carries on, for that stream.
+	var stop func(error)
+	defer func() {
+		if stop != nil {
+			stop(err)
+		}
+	}()
+
+	if o.PartSize <= 0 {
+		o.PartSize = downloadPartSize
+	}
+	parallel := o.Threads > 1 && o.Range == ""
+
+	headers := http.Header{}
+	if o.Range != "" {
+		headers.Set("Range", "bytes="+o.Range)
+	} else {
+		headers.Set("X-Amz-Checksum-Mode", "ENABLED")
+	}
+
+	if to == "-" {
+		if parallel {
+			info, err := stat(c, c.Bucket, key)
+			if err != nil {
+				return 0, err
+			}
+			if info.ContentLength > o.PartSize {
+				stop = startProgress("download", c.Bucket, key, info.ContentLength, 0)
+				n, err := streamParts(c, key, info, o)
+				if err != errNoRanges {
+					return n, err
+				}
+				debugf("%s; streaming @Y{%s}:@C{%s} in one go", err, c.Bucket, key)
+			}
+		}
+
+		res, err := request(c, "GET", c.Bucket, key, nil, headers, nil)
+		if err != nil {
+			return 0, err
+		}
+		if res.StatusCode != 200 && res.StatusCode != 206 {
+			return 0, responseError(res)
+		}
+		if o.Range != "" && res.StatusCode != 206 {
+			res.Body.Close()
+			return 0, rangeIgnored(c, key, o.Range)
+		}
+		defer res.Body.Close()
+
+		debugf("streaming @Y{%s}:@C{%s} to @G{standard output}", c.Bucket, key)
+		if stop == nil {
+			stop = startProgress("download", c.Bucket, key, res.ContentLength, 0)
+		}
+		if o.Range != "" {
+			return io.Copy(os.Stdout, progressReader(res.Body))
+		}
+
+		d := newDownloadCheck(infoFrom(c.Bucket, key, res.Header))
+		n, err := io.Copy(io.MultiWriter(os.Stdout, d), progressReader(res.Body))
+		if err != nil {
+			return n, err
+		}
+		return n, d.check()
+	}
+
+	path, err := filepath.Abs(to)
+	if err != nil {
+		return 0, err
+	}
+	j, err := downloadJournalFor(path)
+	if err != nil {
+		return 0, err
+	}
+	host := endpoint(c, c.Bucket, "", nil).Host
+
+	var offset int64
+	if info, err := os.Stat(to); o.Continue && err == nil && info.Size() > 0 {
+		offset = info.Size()
+		if j.Endpoint != host || j.Bucket != c.Bucket || j.Key != key {
+			// we don't know where this file came from, but if it's
+			// already all there, there's nothing left to do.
+			if info, err := stat(c, c.Bucket, key); err == nil && info.ContentLength == offset {
+				debugf("@C{%s} is already fully downloaded", to)
+				return 0, nil
+			}
+			return 0, fmt.Errorf("%s was not (partially) downloaded from %s:%s by s3, so it can't be safely continued; download it again without --continue", to, c.Bucket, key)
+		}
+
+		if j.PartSize > 0 {
+			info, err := stat(c, c.Bucket, key)
+			if err != nil {
+				return 0, err
+			}
+			debugf("continuing download of @Y{%s}:@C{%s} to @C{%s}: @G{%d} part(s) already downloaded", c.Bucket, key, to, len(j.Parts))
+			var done int64
+			for part := range j.Parts {
+				done += min64(j.PartSize, j.Size-int64(part-1)*j.PartSize)
+			}
+			stop = startProgress("download", c.Bucket, key, j.Size, done)
+			n, err := downloadParts(c, key, to, j, o.Threads, false)
+			if err != nil {
+				return n, err
+			}
+			return n, checkFile(info, to)
+		}
+
+		debugf("continuing download of @Y{%s}:@C{%s} to @C{%s} from byte @W{%d}", c.Bucket, key, to, offset)
+		headers.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		headers.Set("If-Match", j.ETag)
+
+	} else if parallel {
+		info, err := stat(c, c.Bucket, key)
+		if err != nil {
+			return 0, err
+		}
+		if info.ContentLength > o.PartSize {
+			j.Endpoint, j.Bucket, j.Key, j.ETag = host, c.Bucket, key, `"`+info.ETag+`"`
+			j.Size, j.PartSize, j.Parts = info.ContentLength, o.PartSize, make(map[int]bool)
+			stop = startProgress("download", c.Bucket, key, j.Size, 0)
+			n, err := downloadParts(c, key, to, j, o.Threads, true)
+			if err == errNoRanges {
+				debugf("%s; downloading @Y{%s}:@C{%s} in one go", err, c.Bucket, key)
+			} else if err != nil {
+				return n, err
+			} else {
+				return n, checkFile(info, to)
+			}
+		}
+	}
+
+	res, err := request(c, "GET", c.Bucket, key, nil, headers, nil)
+	if err != nil {
+		return 0, err
+	}
+	changed := fmt.Errorf("%s:%s has changed since %s was partially downloaded; download it again without --continue", c.Bucket, key, to)
+	switch {
+	case res.StatusCode == 206 && offset > 0:
+		if res.Header.Get("ETag") != j.ETag {
+			res.Body.Close()
+			return 0, changed
+		}
+
+	case res.StatusCode == 200 && o.Range != "":
+		res.Body.Close()
+		return 0, rangeIgnored(c, key, o.Range)
+
+	case res.StatusCode == 200 || res.StatusCode == 206:
+		// the server ignored our Range header, and sent the whole
+		// object, so we'll have to start over after all.
+		offset = 0
+
+	case res.StatusCode == 412 && offset > 0:
+		res.Body.Close()
+		return 0, changed
+
+	case res.StatusCode == 416 && offset > 0:
+		// there's nothing after the bytes we have; if we have all of
+		// them, and they're from the right version, we're done.
+		res.Body.Close()
+		info, err := stat(c, c.Bucket, key)
+		if err != nil {
+			return 0, err
+		}
+		if info.ETag != strings.Trim(j.ETag, `"`) || info.ContentLength != offset {
+			return 0, changed
+		}
+		debugf("@C{%s} is already fully downloaded", to)
+		j.remove()
+		return 0, nil
+
+	default:
+		return 0, responseError(res)
+	}
+	defer res.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	// only whole objects can be continued; ranges always start over.
+	if o.Range == "" {
+		j.Endpoint, j.Bucket, j.Key, j.ETag = host, c.Bucket, key, res.Header.Get("ETag")
+		j.Size, j.PartSize, j.Parts = 0, 0, nil
+		if err := j.save(); err != nil {
+			warnf("unable to journal download of %s: %s", to, err)
+		}
+	}
+
+	// whole objects are checked against their MD5 and / or checksum
+	// (if they have them) on the way down, including the part we
+	// already had, if any.
+	h := newDownloadCheck(infoFrom(c.Bucket, key, res.Header))
+	if offset > 0 {
+		file, err := os.Open(to)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(h, io.LimitReader(file, offset))
+		file.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	debugf("downloading @Y{%s}:@C{%s} to @C{%s}", c.Bucket, key, to)
+	file, err := os.OpenFile(to, flags, 0666)
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(-1)
+	if res.ContentLength >= 0 {
+		total = offset + res.ContentLength
+	}
+	if stop == nil {
+		stop = startProgress("download", c.Bucket, key, total, offset)
+	}
+	n, err = io.Copy(io.MultiWriter(file, h), progressReader(res.Body))
+	if err != nil {
+		file.Close()
+		if o.Range == "" {
+			return n, fmt.Errorf("%s (run `s3 get --continue` to pick up where this left off)", err)
+		}
+		return n, err
+	}
+	if err := file.Close(); err != nil {
+		return n, err
+	}
+	if o.Range == "" {
+		j.remove()
+		return n, h.check()
+	}
+	return n, nil
+}
+
+// downloadAll fetches every object under prefix in the client's
+// bucket into the local directory root, recreating the key hierarchy
+// as directories, with up to `threads` downloads in flight at once.
+// Keys that can't be downloaded (including any that would land outside
+// of root) are reported, but don't stop the rest of the downloads.
+func downloadAll(c *s3.Client, prefix, root string, threads int, continuing bool) (int64, int64, error) {
+	var (
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+		files  int64
+		total  int64
+		failed int
+	)
+
+	fail := func(key string, err error) {
+		aboveProgress(func() { fmt.Fprintf(os.Stderr, "@R{!!! %s:%s: %s}\n", c.Bucket, key, err) })
+		lock.Lock()
+		failed++
+		lock.Unlock()
+	}
+
+	stop := startProgress("download", c.Bucket, prefix, -1, 0)
+	objects := make(chan s3.Object)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range objects {
+				to, err := localPath(root, strings.TrimPrefix(o.Key, prefix))
+				if err != nil {
+					fail(o.Key, err)
+					continue
+				}
+
+				if strings.HasSuffix(o.Key, "/") {
+					if err := os.MkdirAll(to, 0777); err != nil {
+						fail(o.Key, err)
+					}
+					continue
+				}
+
+				if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+					fail(o.Key, err)
+					continue
+				}
+				n, err := download(c, o.Key, to, downloadOptions{Continue: continuing, Threads: 1})
+				if err != nil {
+					fail(o.Key, err)
+					continue
+				}
+				os.Chtimes(to, o.LastModified, o.LastModified)
+
+				lock.Lock()
+				files++
+				total += n
+				lock.Unlock()
+			}
+		}()
+	}
+
+	err := newListing(c.Bucket, prefix).eachObject(c, func(o s3.Object) error {
+		objects <- o
+		return nil
+	})
+	close(objects)
+	wg.Wait()
+
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("%d key(s) could not be downloaded", failed)
+	}
+	stop(err)
+	return files, total, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+)
+
+const (
+	mib = int64(1 << 20)
+	gib = int64(1 << 30)
+	tib = int64(1 << 40)
+)
+
+func TestFilePartSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want int64
+		part int64
+	}{
+		{"empty file", 0, 0, 5 * mib},
+		{"small file", 100 * mib, 0, 5 * mib},
+		{"10,000 default parts, exactly", maxParts * 5 * mib, 0, 5 * mib},
+		{"one byte too many for 5MiB parts", maxParts*5*mib + 1, 0, 6 * mib},
+		{"120GB file", 120 * 1000 * 1000 * 1000, 0, 12 * mib},
+		{"--part-size that fits", 100 * mib, 64 * mib, 64 * mib},
+		{"--part-size too small for the file", 120 * 1000 * 1000 * 1000, 5 * mib, 12 * mib},
+		{"--part-size bigger than needed", 120 * 1000 * 1000 * 1000, 100 * mib, 100 * mib},
+		{"5TiB file", 5 * tib, 0, 525 * mib},
+	}
+
+	for _, test := range tests {
+		got := filePartSize(test.size, test.want)
+		if got != test.part {
+			t.Errorf("%s: filePartSize(%d, %d) = %d, wanted %d", test.name, test.size, test.want, got, test.part)
+		}
+		if got%mib != 0 && got != test.want {
+			t.Errorf("%s: part size %d isn't in whole MiB", test.name, got)
+		}
+		if (test.size+got-1)/got > maxParts {
+			t.Errorf("%s: %d byte parts would take more than %d parts", test.name, got, maxParts)
+		}
+	}
+}
+
+func TestStreamPartSize(t *testing.T) {
+	tests := []struct {
+		n    int
+		base int64
+		part int64
+	}{
+		{1, 0, 5 * mib},
+		{1000, 0, 5 * mib},
+		{1001, 0, 10 * mib},
+		{2001, 0, 20 * mib},
+		{9000, 0, 5 * mib << 8},
+		{9001, 0, maxPartSize},
+		{10000, 0, maxPartSize},
+		{1, 100 * mib, 100 * mib},
+		{1001, 100 * mib, 200 * mib},
+		{8000, 100 * mib, maxPartSize},
+		{1, maxPartSize, maxPartSize},
+		{5000, maxPartSize, maxPartSize},
+
+		// big enough to overflow, once it's doubled a few times.
+		{5000, 1 << 60, maxPartSize},
+	}
+
+	for _, test := range tests {
+		if got := streamPartSize(test.n, test.base); got != test.part {
+			t.Errorf("streamPartSize(%d, %d) = %d, wanted %d", test.n, test.base, got, test.part)
+		}
+	}
+}
+
+// however big a stream turns out to be, its parts have to be able to
+// add up to the biggest object S3 allows, without any of them being
+// too big for S3.
+func TestStreamPartsAddUp(t *testing.T) {
+	for _, base := range []int64{0, 5 * mib, 8 * mib, 100 * mib, maxPartSize} {
+		var total int64
+		for n := 1; n <= maxParts; n++ {
+			size := streamPartSize(n, base)
+			if size > maxPartSize {
+				t.Fatalf("part %d (from %d) is %d bytes, which is bigger than %d", n, base, size, maxPartSize)
+			}
+			if n > 1 && size < streamPartSize(n-1, base) {
+				t.Fatalf("part %d (from %d) is smaller than part %d", n, base, n-1)
+			}
+			total += size
+		}
+		if total < 5*tib {
+			t.Errorf("%d parts starting at %d add up to %d bytes, which is less than 5TiB", maxParts, base, total)
+		}
+	}
+}
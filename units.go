@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// duration parses a human-friendly length of time, like 15m, 1h, or
+// 7d.  Go's time.ParseDuration doesn't know about days, so we do.
+func duration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+	return d, nil
+}
+
+// bytesize parses a human-friendly size, like 512, 64k, 100M or
+// 5GiB, into a number of bytes.  Units are powers of 1024, the same
+// as the sizes s3.Bytes prints out.
+func bytesize(s string) (int64, error) {
+	units := map[string]int64{
+		"":  1,
+		"k": 1 << 10,
+		"m": 1 << 20,
+		"g": 1 << 30,
+		"t": 1 << 40,
+	}
+
+	rest := strings.TrimLeft(s, "0123456789.")
+	num, unit := s[:len(s)-len(rest)], strings.ToLower(rest)
+	if strings.HasSuffix(unit, "ib") {
+		unit = strings.TrimSuffix(unit, "ib")
+	} else {
+		unit = strings.TrimSuffix(unit, "b")
+	}
+
+	mult, ok := units[unit]
+	n, err := strconv.ParseFloat(num, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// byterange checks a byte range, as given to --range: FIRST-LAST
+// (both inclusive), FIRST- (through the end), or -N (the last N
+// bytes), and returns it in the form the Range header wants.
+func byterange(s string) (string, error) {
+	i := strings.Index(s, "-")
+	if i < 0 || s == "-" {
+		return "", fmt.Errorf("invalid byte range '%s' (must be FIRST-LAST, FIRST-, or -N)", s)
+	}
+	first, last := s[:i], s[i+1:]
+
+	var a, b uint64
+	var err error
+	if first != "" {
+		if a, err = strconv.ParseUint(first, 10, 63); err != nil {
+			return "", fmt.Errorf("invalid byte range '%s' (must be FIRST-LAST, FIRST-, or -N)", s)
+		}
+	}
+	if last != "" {
+		if b, err = strconv.ParseUint(last, 10, 63); err != nil {
+			return "", fmt.Errorf("invalid byte range '%s' (must be FIRST-LAST, FIRST-, or -N)", s)
+		}
+	}
+	if first != "" && last != "" && b < a {
+		return "", fmt.Errorf("invalid byte range '%s' (the last byte comes before the first)", s)
+	}
+	if first == "" && b == 0 {
+		return "", fmt.Errorf("invalid byte range '%s' (must be FIRST-LAST, FIRST-, or -N)", s)
+	}
+	return s, nil
+}
+
+// partSizeOption parses a --part-size option.  If it wasn't given,
+// partSizeOption returns 0, to mean "use the default".
+func partSizeOption(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := bytesize(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --part-size '%s'", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/jhunt/go-s3"
+)
+
+// An inflight is a multipart upload that has been started, but has
+// not (yet) been completed or aborted.  S3 holds onto the parts of
+// these indefinitely, and bills for them, so uploads that were
+// interrupted and never resumed have to be cleaned up by hand, or
+// by `s3 uploads prune`.
+type inflight struct {
+	Key          string    `xml:"Key"`
+	UploadID     string    `xml:"UploadId"`
+	Initiated    time.Time `xml:"Initiated"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+// multipart returns a handle on the upload, for listing its parts,
+// or aborting it.
+func (u inflight) multipart(c *s3.Client, bucket string) *multipart {
+	return &multipart{c: c, Bucket: bucket, Key: u.Key, ID: u.UploadID}
+}
+
+// listUploads walks the in-flight multipart uploads of keys under
+// prefix, via the ListMultipartUploads API, handing each page of
+// them to fn as soon as it arrives.  If fn returns an error, the
+// walk stops and returns that error.
+func listUploads(c *s3.Client, bucket, prefix string, fn func([]inflight) error) error {
+	var keyMarker, idMarker string
+	for {
+		q := url.Values{"uploads": {""}}
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if keyMarker != "" {
+			q.Set("key-marker", keyMarker)
+			q.Set("upload-id-marker", idMarker)
+		}
+
+		res, err := request(c, "GET", bucket, "", q, nil, nil)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != 200 {
+			return responseError(res)
+		}
+
+		var r struct {
+			IsTruncated        bool       `xml:"IsTruncated"`
+			NextKeyMarker      string     `xml:"NextKeyMarker"`
+			NextUploadIDMarker string     `xml:"NextUploadIdMarker"`
+			Uploads            []inflight `xml:"Upload"`
+		}
+		if err := readXML(res, &r); err != nil {
+			return err
+		}
+		if err := fn(r.Uploads); err != nil {
+			return err
+		}
+		if !r.IsTruncated || r.NextKeyMarker == "" {
+			return nil
+		}
+		keyMarker, idMarker = r.NextKeyMarker, r.NextUploadIDMarker
+	}
+}
+
+// pruneUploads aborts every in-flight upload under prefix that was
+// started before the cutoff, calling fn for each one, whether or not
+// it could be aborted.  With dryRun, nothing is actually aborted.
+// pruneUploads returns how many uploads were (or would have been)
+// aborted, and how many couldn't be.
+func pruneUploads(c *s3.Client, bucket, prefix string, cutoff time.Time, dryRun bool, fn func(inflight, error)) (int, int, error) {
+	var pruned, failed int
+	err := listUploads(c, bucket, prefix, func(uploads []inflight) error {
+		for _, u := range uploads {
+			if !u.Initiated.Before(cutoff) {
+				continue
+			}
+			var err error
+			if !dryRun {
+				err = u.multipart(c, bucket).abort()
+			}
+			if err != nil {
+				failed++
+			} else {
+				pruned++
+			}
+			fn(u, err)
+		}
+		return nil
+	})
+	return pruned, failed, err
+}